@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"versiy/internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const searchLimit = 100
+
+type disableRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+	Status int    `json:"status" validate:"omitempty,oneof=410 451"`
+}
+
+func (req *disableRequest) status() int {
+	if req.Status == 0 {
+		return http.StatusGone
+	}
+	return req.Status
+}
+
+func (app *application) DisableLink(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "code")
+
+	var req disableRequest
+	if err := decodeJSON(r, &req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	if err := Validate.Struct(&req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := app.store.Links.Disable(ctx, shortCode, req.Reason, req.status(), adminActor(r)); err != nil {
+		switch err {
+		case database.ErrLinkNotFound:
+			app.notFoundError(w)
+		default:
+			app.internalServerError(w, err)
+		}
+		return
+	}
+
+	if err := app.store.Cache.Invalidate(ctx, shortCode); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) DisableDomain(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domain string `json:"domain" validate:"required,fqdn"`
+		disableRequest
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	if err := Validate.Struct(&req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	domain := strings.TrimSuffix(strings.ToLower(req.Domain), ".")
+	disabled, err := app.store.Links.DisableDomain(ctx, domain, req.Reason, req.status(), adminActor(r))
+	if err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	if err := app.store.Cache.Invalidate(ctx, disabled...); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	if err := encodeJSON(w, map[string]any{
+		"domain":   domain,
+		"disabled": len(disabled),
+	}, http.StatusOK); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+}
+
+func (app *application) BlockCreator(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind   string `json:"kind" validate:"required,oneof=ip device_id"`
+		Value  string `json:"value" validate:"required"`
+		Reason string `json:"reason" validate:"required,max=500"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	if err := Validate.Struct(&req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	switch req.Kind {
+	case database.BlockKindIP:
+		ip := net.ParseIP(req.Value)
+		if ip == nil {
+			app.badRequest(w, errors.New("invalid ip address"))
+			return
+		}
+		req.Value = ip.String()
+	case database.BlockKindDeviceID:
+		id, err := uuid.Parse(req.Value)
+		if err != nil {
+			app.badRequest(w, errors.New("invalid device id"))
+			return
+		}
+		req.Value = id.String()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := app.store.Moderation.BlockCreator(ctx, req.Kind, req.Value, req.Reason, adminActor(r)); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) SearchLinks(w http.ResponseWriter, r *http.Request) {
+	destination := strings.TrimSpace(r.URL.Query().Get("destination"))
+	if destination == "" {
+		app.badRequest(w, errors.New("destination query parameter is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	links, err := app.store.Links.Search(ctx, destination, searchLimit)
+	if err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	if err := app.store.Moderation.Audit(ctx, "link.search", adminActor(r), destination, map[string]any{
+		"results": len(links),
+	}); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	if err := encodeJSON(w, map[string]any{
+		"links": links,
+	}, http.StatusOK); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+}
+
+func (app *application) HotLinks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	links, err := app.store.Hot.Top(ctx, app.cfg.redisConfig.hotLinks)
+	if err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	if err := encodeJSON(w, map[string]any{
+		"links": links,
+	}, http.StatusOK); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+}
+
+func adminActor(r *http.Request) string {
+	return "admin@" + clientIP(r)
+}
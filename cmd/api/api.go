@@ -1,40 +1,72 @@
 package main
 
 import (
+	"expvar"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"versiy/internal/database"
+	"versiy/internal/security"
+	"versiy/internal/util"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 type application struct {
-	store database.Storage
-	cfg   config
-	env   string
-	mut   *sync.Mutex
+	store   database.Storage
+	cfg     config
+	env     string
+	mut     *sync.Mutex
+	proxies *security.ProxyResolver
+	buckets *security.ClientBuckets
 }
 
 type config struct {
 	addr           string
+	backend        string
 	secret         string
+	adminToken     string
+	trustedProxies []string
+	proxyHeader    string
 	defaultLink    string
 	postgresConfig postgreSQLConfig
+	sqlitePath     string
 	redisConfig    redisConfig
 	rateLimiting   rateLimitConfig
+	scanning       scanConfig
+	domainThrottle domainThrottleConfig
+	reaper         database.ReaperOptions
+	codes          util.CodeOptions
 }
 
 type postgreSQLConfig struct {
 	addr           string
 	max_idle_conns int
+	migrateOnBoot  bool
+	replicas       database.ReplicaOptions
 }
 
 type redisConfig struct {
+	mode         string
 	addr         string
 	pswd         string
+	addrs        []string
+	masterName   string
+	sentinelPswd string
+	tlsCAFile    string
+	tlsCertFile  string
+	tlsKeyFile   string
 	defualtTTL   time.Duration
+	notFoundTTL  time.Duration
+	localSize    int
+	localTTL     time.Duration
+	fillLockTTL  time.Duration
+	hotLinks     int
+	hotInterval  time.Duration
 	dialTimeout  time.Duration
 	readTimeout  time.Duration
 	writeTimeout time.Duration
@@ -42,14 +74,73 @@ type redisConfig struct {
 }
 
 type rateLimitConfig struct {
-	size     int
-	duration time.Duration
+	strategy    string
+	fallback    string
+	healthCheck time.Duration
+	ipv4Prefix  int
+	ipv6Prefix  int
+	allowlist   []string
+	policies    map[string]rateLimitPolicy
+}
+
+// scanConfig controls how clients that request many unknown short codes
+// are slowed down and blocked.
+type scanConfig struct {
+	misses     rateLimitPolicy
+	delayStep  time.Duration
+	maxDelay   time.Duration
+	blockAfter int
+	blockFor   time.Duration
+	honeypots  map[string]struct{}
+}
+
+// domainThrottleConfig limits how many links may point to one registrable
+// domain within the window, counted across all clients. Zero disables a
+// threshold.
+type domainThrottleConfig struct {
+	window      time.Duration
+	reviewAfter int
+	blockAfter  int
+	allowlist   map[string]struct{}
+}
+
+// rateLimitPolicy allows limit requests per window for every client.
+type rateLimitPolicy struct {
+	name   string
+	limit  int
+	window time.Duration
+}
+
+const (
+	policyCreate   = "create"
+	policyRedirect = "redirect"
+)
+
+// parseRateLimitPolicy parses a policy written as "<limit>/<window>",
+// for example "10/15s".
+func parseRateLimitPolicy(name, spec string) (rateLimitPolicy, error) {
+	limit, window, ok := strings.Cut(spec, "/")
+	if !ok {
+		return rateLimitPolicy{}, fmt.Errorf("rate limit policy %s: expected <limit>/<window>, got %q", name, spec)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n <= 0 {
+		return rateLimitPolicy{}, fmt.Errorf("rate limit policy %s: invalid limit %q", name, limit)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil || d <= 0 {
+		return rateLimitPolicy{}, fmt.Errorf("rate limit policy %s: invalid window %q", name, window)
+	}
+
+	return rateLimitPolicy{name: name, limit: n, window: d}, nil
 }
 
 func (app *application) mount() *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(app.realIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -60,11 +151,25 @@ func (app *application) mount() *chi.Mux {
 	r.Get("/health", app.health)
 
 	r.Group(func(r chi.Router) {
-		r.Use(app.fixedSizeWindow)
+		r.Use(app.rateLimit(policyCreate))
 		r.Post("/", app.StoreURL)
 	})
 
-	r.Get("/{code}", app.GetURL)
+	if app.cfg.adminToken != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.requireAdmin)
+			r.Method(http.MethodGet, "/metrics", expvar.Handler())
+			r.Get("/links", app.SearchLinks)
+			r.Post("/links/{code}/disable", app.DisableLink)
+			r.Post("/domains/disable", app.DisableDomain)
+			r.Post("/blocks", app.BlockCreator)
+			r.Get("/hot", app.HotLinks)
+			r.Get("/reviews", app.PendingReviews)
+			r.Post("/reviews/{id}/resolve", app.ResolveReview)
+		})
+	}
+
+	r.With(app.rateLimit(policyRedirect), app.scanGuard).Get("/{code}", app.GetURL)
 
 	return r
 }
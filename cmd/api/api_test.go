@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"versiy/internal/database"
+	"versiy/internal/security"
+	"versiy/internal/util"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	testDomain     = "https://api.versiy.cc/"
+	testAdminToken = "test-admin-token-test-admin-token"
+)
+
+// newTestApp mounts the API on the memory backend, so handlers run without
+// PostgreSQL or Redis.
+func newTestApp(t *testing.T) *chi.Mux {
+	t.Helper()
+
+	codes, err := util.NewCodeGenerator(util.CodeOptions{Secret: strings.Repeat("s", 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := security.NewProxyResolver(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := security.NewClientBuckets(32, 64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		cfg: config{
+			adminToken:  testAdminToken,
+			defaultLink: testDomain,
+			redisConfig: redisConfig{defualtTTL: time.Hour},
+			rateLimiting: rateLimitConfig{
+				policies: map[string]rateLimitPolicy{
+					policyCreate:   {name: policyCreate, limit: 100, window: time.Minute},
+					policyRedirect: {name: policyRedirect, limit: 100, window: time.Minute},
+				},
+			},
+			scanning: scanConfig{
+				misses:     rateLimitPolicy{name: "scan", limit: 100, window: time.Minute},
+				blockAfter: 100,
+				blockFor:   time.Minute,
+			},
+			domainThrottle: domainThrottleConfig{window: time.Hour},
+		},
+		store: database.NewMemoryStorage(codes, database.CacheOptions{
+			Local: database.NewLocalCache(100, time.Minute),
+		}),
+		mut:     &sync.Mutex{},
+		proxies: proxies,
+		buckets: buckets,
+	}
+	return app.mount()
+}
+
+func serve(t *testing.T, h http.Handler, method, target, body string, admin bool) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if admin {
+		r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCreateRedirectDisable(t *testing.T) {
+	h := newTestApp(t)
+
+	w := serve(t, h, http.MethodPost, testDomain, `{"original_url":"https://example.com/page"}`, false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	code, ok := strings.CutPrefix(created.URL, testDomain)
+	if !ok || code == "" {
+		t.Fatalf("create: unexpected url %q", created.URL)
+	}
+
+	w = serve(t, h, http.MethodPost, testDomain, `{"original_url":"https://example.com/page"}`, false)
+	if !strings.Contains(w.Body.String(), created.URL) {
+		t.Errorf("create again: got %s, want the same url %s", w.Body, created.URL)
+	}
+
+	w = serve(t, h, http.MethodGet, testDomain+code, "", false)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/page" {
+		t.Fatalf("redirect: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serve(t, h, http.MethodPost, testDomain+"admin/links/"+code+"/disable", `{"reason":"reported as phishing"}`, false)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("disable without token: got %d", w.Code)
+	}
+
+	w = serve(t, h, http.MethodPost, testDomain+"admin/links/"+code+"/disable", `{"reason":"reported as phishing"}`, true)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("disable: got %d: %s", w.Code, w.Body)
+	}
+
+	w = serve(t, h, http.MethodGet, testDomain+code, "", false)
+	if w.Code != http.StatusGone {
+		t.Errorf("redirect after disable: got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "phishing") {
+		t.Errorf("redirect after disable leaks the admin reason: %s", w.Body)
+	}
+
+	w = serve(t, h, http.MethodGet, testDomain+"unknown1", "", false)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown code: got %d", w.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"versiy/internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const reviewsLimit = 100
+
+var errDomainThrottled = errors.New("too many links to this domain were created recently")
+
+// throttleDomain reserves a creation for the destination domain across all
+// clients, counting the link about to be created. It returns
+// errDomainThrottled once the domain is past the block threshold, and the
+// hold to create the new link with when it must be reviewed first. reserved
+// reports whether the caller must give the reservation back with
+// releaseCreation when no link is created. Redis failures are logged and
+// let the creation through.
+func (app *application) throttleDomain(ctx context.Context, domain string) (review *database.ReviewHold, reserved bool, err error) {
+	cfg := app.cfg.domainThrottle
+	if _, ok := cfg.allowlist[domain]; ok {
+		return nil, false, nil
+	}
+
+	count, err := app.store.Domains.ReserveCreation(ctx, domain, cfg.window)
+	if err != nil {
+		log.Printf("domain throttle: %v", err)
+		return nil, false, nil
+	}
+
+	if cfg.blockAfter > 0 && count > cfg.blockAfter {
+		app.releaseCreation(ctx, domain)
+		return nil, false, errDomainThrottled
+	}
+
+	if cfg.reviewAfter > 0 && count > cfg.reviewAfter {
+		return &database.ReviewHold{
+			Domain: domain,
+			Reason: fmt.Sprintf("more than %d links to %s within %s", cfg.reviewAfter, domain, cfg.window),
+		}, true, nil
+	}
+	return nil, true, nil
+}
+
+// releaseCreation gives back a creation reserved by throttleDomain when the
+// request was deduplicated or failed, so only created links are counted.
+func (app *application) releaseCreation(ctx context.Context, domain string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
+	defer cancel()
+
+	if err := app.store.Domains.ReleaseCreation(ctx, domain, app.cfg.domainThrottle.window); err != nil {
+		log.Printf("domain throttle: %v", err)
+	}
+}
+
+func (app *application) PendingReviews(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	reviews, err := app.store.Domains.PendingReviews(ctx, reviewsLimit)
+	if err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	if err := encodeJSON(w, map[string]any{
+		"reviews": reviews,
+	}, http.StatusOK); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+}
+
+// ResolveReview approves a held link, which makes it redirect, or rejects
+// it, which disables it for good.
+func (app *application) ResolveReview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, errors.New("invalid review id"))
+		return
+	}
+
+	var req struct {
+		Decision string `json:"decision" validate:"required,oneof=approve reject"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	if err := Validate.Struct(&req); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	shortCode, err := app.store.Domains.ResolveReview(ctx, id, req.Decision == "approve", adminActor(r))
+	if err != nil {
+		switch err {
+		case database.ErrReviewNotFound:
+			app.notFoundError(w)
+		default:
+			app.internalServerError(w, err)
+		}
+		return
+	}
+
+	if err := app.store.Cache.Invalidate(ctx, shortCode); err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
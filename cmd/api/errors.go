@@ -32,3 +32,19 @@ func (app *application) internalServerError(w http.ResponseWriter, err error) {
 func (app *application) notFoundError(w http.ResponseWriter) {
 	responseError(w, errors.New("not found"), http.StatusNotFound)
 }
+
+func (app *application) unauthorizedError(w http.ResponseWriter, err error) {
+	responseError(w, err, http.StatusUnauthorized)
+}
+
+func (app *application) forbiddenError(w http.ResponseWriter, err error) {
+	responseError(w, err, http.StatusForbidden)
+}
+
+func (app *application) tooManyRequestsError(w http.ResponseWriter, err error) {
+	responseError(w, err, http.StatusTooManyRequests)
+}
+
+func (app *application) serviceUnavailableError(w http.ResponseWriter, err error) {
+	responseError(w, err, http.StatusServiceUnavailable)
+}
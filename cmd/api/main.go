@@ -2,36 +2,139 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"time"
 	"versiy/env"
 	"versiy/internal/database"
+	"versiy/internal/security"
+	"versiy/internal/util"
 )
 
 func main() {
 	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, env.GetString("POSTGRES_ADDR", ""), os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg := config{
-		addr: env.GetString("ADDR", ":3000"),
+		addr:    env.GetString("ADDR", ":3000"),
+		backend: env.GetString("STORAGE_BACKEND", database.BackendPostgres),
 		postgresConfig: postgreSQLConfig{
-			addr: env.GetString("POSTGRES_ADDR", ""),
+			addr:          env.GetString("POSTGRES_ADDR", ""),
+			migrateOnBoot: env.GetBool("MIGRATE_ON_BOOT", false),
+			replicas: database.ReplicaOptions{
+				Addrs:         splitList(env.GetString("POSTGRES_REPLICA_ADDRS", "")),
+				MaxLag:        env.GetDuration("POSTGRES_REPLICA_MAX_LAG", 5*time.Second),
+				CheckInterval: env.GetDuration("POSTGRES_REPLICA_CHECK_INTERVAL", 5*time.Second),
+				MissFallbacks: env.GetInt("POSTGRES_REPLICA_MISS_FALLBACKS", 50),
+			},
 		},
+		sqlitePath: env.GetString("SQLITE_PATH", "versiy.db"),
 		redisConfig: redisConfig{
+			mode:         env.GetString("REDIS_MODE", database.RedisStandalone),
 			addr:         env.GetString("REDIS_ADDR", ""),
 			pswd:         env.GetString("REDIS_PASSWORD", ""),
+			addrs:        splitList(env.GetString("REDIS_ADDRS", "")),
+			masterName:   env.GetString("REDIS_SENTINEL_MASTER", ""),
+			sentinelPswd: env.GetString("REDIS_SENTINEL_PASSWORD", ""),
+			tlsCAFile:    env.GetString("REDIS_TLS_CA_FILE", ""),
+			tlsCertFile:  env.GetString("REDIS_TLS_CERT_FILE", ""),
+			tlsKeyFile:   env.GetString("REDIS_TLS_KEY_FILE", ""),
 			defualtTTL:   time.Duration(time.Hour * 24),
+			notFoundTTL:  env.GetDuration("NOT_FOUND_CACHE_TTL", time.Minute),
+			localSize:    env.GetInt("LOCAL_CACHE_SIZE", 10000),
+			localTTL:     env.GetDuration("LOCAL_CACHE_TTL", 30*time.Second),
+			fillLockTTL:  env.GetDuration("CACHE_FILL_LOCK_TTL", 0),
+			hotLinks:     env.GetInt("HOT_LINKS", 100),
+			hotInterval:  env.GetDuration("HOT_LINKS_INTERVAL", 30*time.Second),
 			dialTimeout:  10 * time.Second,
 			readTimeout:  5 * time.Second,
 			writeTimeout: 5 * time.Second,
 			poolTimeout:  5 * time.Second,
 		},
-		secret:      env.GetString("SECRET", ""),
-		defaultLink: env.GetString("DEFAULT_DOMAIN", ""),
+		secret:         env.GetString("SECRET", ""),
+		adminToken:     env.GetString("ADMIN_TOKEN", ""),
+		trustedProxies: splitList(env.GetString("TRUSTED_PROXIES", "")),
+		proxyHeader:    env.GetString("TRUSTED_PROXY_HEADER", security.HeaderXForwardedFor),
+		defaultLink:    env.GetString("DEFAULT_DOMAIN", ""),
 		rateLimiting: rateLimitConfig{
-			size:     10,
-			duration: time.Duration(time.Second * 15),
+			strategy:    env.GetString("RATE_LIMIT_STRATEGY", database.StrategyFixedWindow),
+			fallback:    env.GetString("RATE_LIMIT_FALLBACK", database.FallbackOpen),
+			healthCheck: 5 * time.Second,
+			ipv4Prefix:  env.GetInt("RATE_LIMIT_IPV4_PREFIX", 32),
+			ipv6Prefix:  env.GetInt("RATE_LIMIT_IPV6_PREFIX", 64),
+			allowlist:   splitList(env.GetString("RATE_LIMIT_ALLOWLIST", "")),
+			policies:    map[string]rateLimitPolicy{},
 		},
 	}
 
+	for name, spec := range map[string]string{
+		policyCreate:   env.GetString("RATE_LIMIT_CREATE", "10/15s"),
+		policyRedirect: env.GetString("RATE_LIMIT_REDIRECT", "120/1m"),
+	} {
+		policy, err := parseRateLimitPolicy(name, spec)
+		if err != nil {
+			panic(err)
+		}
+		cfg.rateLimiting.policies[name] = policy
+	}
+
+	misses, err := parseRateLimitPolicy("scan", env.GetString("SCAN_MISSES", "20/1m"))
+	if err != nil {
+		panic(err)
+	}
+	cfg.scanning = scanConfig{
+		misses:     misses,
+		delayStep:  env.GetDuration("SCAN_DELAY_STEP", 250*time.Millisecond),
+		maxDelay:   env.GetDuration("SCAN_MAX_DELAY", 5*time.Second),
+		blockAfter: env.GetInt("SCAN_BLOCK_AFTER", 60),
+		blockFor:   env.GetDuration("SCAN_BLOCK_DURATION", 15*time.Minute),
+		honeypots:  map[string]struct{}{},
+	}
+	honeypots := splitList(env.GetString("HONEYPOT_CODES", ""))
+	for _, code := range honeypots {
+		cfg.scanning.honeypots[code] = struct{}{}
+	}
+
+	cfg.domainThrottle = domainThrottleConfig{
+		window:      env.GetDuration("DOMAIN_THROTTLE_WINDOW", time.Hour),
+		reviewAfter: env.GetInt("DOMAIN_REVIEW_AFTER", 100),
+		blockAfter:  env.GetInt("DOMAIN_BLOCK_AFTER", 500),
+		allowlist:   map[string]struct{}{},
+	}
+	for _, domain := range splitList(env.GetString("DOMAIN_ALLOWLIST", "")) {
+		cfg.domainThrottle.allowlist[strings.ToLower(domain)] = struct{}{}
+	}
+
+	cfg.reaper = database.ReaperOptions{
+		Interval:  env.GetDuration("REAPER_INTERVAL", time.Hour),
+		Grace:     env.GetDuration("REAPER_GRACE", 7*24*time.Hour),
+		BatchSize: env.GetInt("REAPER_BATCH_SIZE", 1000),
+	}
+	switch mode := env.GetString("REAPER_MODE", "archive"); mode {
+	case "archive":
+		cfg.reaper.Archive = true
+	case "delete":
+	default:
+		panic(fmt.Sprintf("REAPER_MODE must be archive or delete, got %q", mode))
+	}
+
+	cfg.codes = util.CodeOptions{
+		Strategy:         env.GetString("CODE_STRATEGY", util.CodeHashed),
+		Length:           env.GetInt("CODE_LENGTH", 0),
+		Alphabet:         env.GetString("CODE_ALPHABET", ""),
+		ExcludeAmbiguous: env.GetBool("CODE_EXCLUDE_AMBIGUOUS", false),
+		Secret:           cfg.secret,
+		Reserved:         honeypots,
+	}
+
 	if cfg.secret == "" {
 		panic("SECRET environment variable is required")
 	}
@@ -40,23 +143,155 @@ func main() {
 		panic("SECRET must be at least 32 characters")
 	}
 
-	pool, err := database.NewDBConn(ctx, cfg.postgresConfig.addr)
+	if cfg.adminToken != "" && len(cfg.adminToken) < 32 {
+		panic("ADMIN_TOKEN must be at least 32 characters")
+	}
+
+	if cfg.redisConfig.hotLinks > 0 && cfg.redisConfig.hotInterval <= 0 {
+		panic("HOT_LINKS_INTERVAL must be positive when HOT_LINKS is set")
+	}
+
+	proxies, err := security.NewProxyResolver(cfg.trustedProxies, cfg.proxyHeader)
+	if err != nil {
+		panic(err)
+	}
+
+	buckets, err := security.NewClientBuckets(cfg.rateLimiting.ipv4Prefix, cfg.rateLimiting.ipv6Prefix, cfg.rateLimiting.allowlist)
 	if err != nil {
 		panic(err)
 	}
 
-	redisClient := database.NewRedisConn(ctx, cfg.redisConfig.addr, cfg.redisConfig.pswd, cfg.redisConfig.dialTimeout, cfg.redisConfig.readTimeout, cfg.redisConfig.writeTimeout, cfg.redisConfig.poolTimeout)
+	store, closeStore, err := openStorage(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer closeStore()
 
-	defer pool.Close()
-	defer redisClient.Close()
+	if err := checkHoneypots(ctx, store.Links, honeypots); err != nil {
+		panic(err)
+	}
 
 	app := application{
-		cfg:   cfg,
-		store: database.NewStorage(pool, redisClient),
-		env:   env.GetString("ENVIRONMENT", "development"),
-		mut:   &sync.Mutex{},
+		cfg:     cfg,
+		store:   store,
+		env:     env.GetString("ENVIRONMENT", "development"),
+		mut:     &sync.Mutex{},
+		proxies: proxies,
+		buckets: buckets,
 	}
 
+	go app.store.Hot.Run(ctx)
+	go app.store.Scans.Run(ctx)
+	go app.store.Hot.WarmUp(ctx, cfg.redisConfig.defualtTTL)
+	go database.RunReaper(ctx, app.store.Links, cfg.reaper)
+
 	r := app.mount()
 	app.run(r)
 }
+
+// openStorage connects the configured backend. The returned function
+// releases its connections.
+func openStorage(ctx context.Context, cfg config) (database.Storage, func(), error) {
+	codes, err := util.NewCodeGenerator(cfg.codes)
+	if err != nil {
+		return database.Storage{}, nil, err
+	}
+
+	localCache := database.NewLocalCache(cfg.redisConfig.localSize, cfg.redisConfig.localTTL)
+	cacheOpts := database.CacheOptions{
+		Local:       localCache,
+		FillLockTTL: cfg.redisConfig.fillLockTTL,
+		NotFoundTTL: cfg.redisConfig.notFoundTTL,
+		HotLinks:    cfg.redisConfig.hotLinks,
+		HotInterval: cfg.redisConfig.hotInterval,
+	}
+
+	switch cfg.backend {
+	case database.BackendMemory:
+		return database.NewMemoryStorage(codes, cacheOpts), func() {}, nil
+	case database.BackendSQLite:
+		db, err := database.NewSQLiteConn(ctx, cfg.sqlitePath)
+		if err != nil {
+			return database.Storage{}, nil, err
+		}
+		return database.NewSQLiteStorage(db, codes, cacheOpts), func() { db.Close() }, nil
+	case database.BackendPostgres:
+	default:
+		return database.Storage{}, nil, fmt.Errorf("unknown storage backend %q", cfg.backend)
+	}
+
+	pool, err := database.NewDBConn(ctx, cfg.postgresConfig.addr)
+	if err != nil {
+		return database.Storage{}, nil, err
+	}
+
+	if cfg.postgresConfig.migrateOnBoot {
+		if err := migrateOnBoot(ctx, pool); err != nil {
+			pool.Close()
+			return database.Storage{}, nil, err
+		}
+	}
+
+	replicas, err := database.NewReplicas(ctx, pool, cfg.postgresConfig.replicas)
+	if err != nil {
+		pool.Close()
+		return database.Storage{}, nil, err
+	}
+
+	redisClient, err := database.NewRedisConn(ctx, database.RedisOptions{
+		Mode:             cfg.redisConfig.mode,
+		Addr:             cfg.redisConfig.addr,
+		Password:         cfg.redisConfig.pswd,
+		Addrs:            cfg.redisConfig.addrs,
+		MasterName:       cfg.redisConfig.masterName,
+		SentinelPassword: cfg.redisConfig.sentinelPswd,
+		TLSCAFile:        cfg.redisConfig.tlsCAFile,
+		TLSCertFile:      cfg.redisConfig.tlsCertFile,
+		TLSKeyFile:       cfg.redisConfig.tlsKeyFile,
+		DialTimeout:      cfg.redisConfig.dialTimeout,
+		ReadTimeout:      cfg.redisConfig.readTimeout,
+		WriteTimeout:     cfg.redisConfig.writeTimeout,
+		PoolTimeout:      cfg.redisConfig.poolTimeout,
+	})
+	if err != nil {
+		replicas.Close()
+		pool.Close()
+		return database.Storage{}, nil, err
+	}
+
+	closeStore := func() {
+		redisClient.Close()
+		replicas.Close()
+		pool.Close()
+	}
+
+	limiter, err := database.NewRateLimiter(cfg.rateLimiting.strategy, redisClient)
+	if err != nil {
+		closeStore()
+		return database.Storage{}, nil, err
+	}
+
+	limiter, err = database.NewFallbackLimiter(limiter, cfg.rateLimiting.fallback, func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	}, cfg.rateLimiting.healthCheck)
+	if err != nil {
+		closeStore()
+		return database.Storage{}, nil, err
+	}
+
+	go database.ListenForInvalidations(ctx, redisClient, localCache, replicas)
+	go replicas.Run(ctx)
+
+	return database.NewStorage(pool, replicas, redisClient, limiter, codes, cacheOpts), closeStore, nil
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
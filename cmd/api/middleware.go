@@ -2,10 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"versiy/internal/database"
 	"versiy/internal/security"
 )
 
@@ -13,36 +20,68 @@ type deviceIDKeyType string
 
 const deviceIDKey deviceIDKeyType = "device_id"
 
-func (app *application) fixedSizeWindow(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+type clientIPKeyType string
 
-		// Extract X-Forwarded-For header for proxy/load balancer cases
-		xForwardedFor := r.Header.Get("X-Forwarded-For")
+const clientIPKey clientIPKeyType = "client_ip"
 
-		// Get device ID from context if available
-		idFromCtx := getValFromContext(ctx)
+func (app *application) rateLimit(name string) func(http.Handler) http.Handler {
+	policy, ok := app.cfg.rateLimiting.policies[name]
+	if !ok {
+		panic(fmt.Sprintf("rate limit policy %q is not configured", name))
+	}
 
-		// Get rate limit identifier (prefers IP, falls back to cookie)
-		rateLimitKey := security.GetRateLimitIdentifier(r.RemoteAddr, xForwardedFor, idFromCtx)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
 
-		// Increment rate limit counter
-		req, err := app.store.Users.IncrUser(ctx, rateLimitKey, app.cfg.rateLimiting.duration)
-		if err != nil {
-			app.internalServerError(w, err)
-			return
-		}
+			if app.buckets.Allowlisted(clientIP(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		// Check if rate limit exceeded
-		if req > app.cfg.rateLimiting.size {
-			w.Header().Set("Retry-After", strconv.Itoa(int(app.cfg.rateLimiting.duration)))
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
-			return
+			rateLimitKey := policy.name + ":" + app.rateLimitID(r)
+
+			// Record the request and check if rate limit exceeded
+			res, err := app.store.Limiter.Allow(ctx, rateLimitKey, policy.limit, policy.window)
+			if errors.Is(err, database.ErrLimiterUnavailable) {
+				app.serviceUnavailableError(w, err)
+				return
+			}
+			if err != nil {
+				app.internalServerError(w, err)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.limit, seconds(policy.window)))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(res.ResetAfter)))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+				app.tooManyRequestsError(w, errors.New("rate limit exceeded"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
 		}
+		return http.HandlerFunc(fn)
+	}
+}
 
-		next.ServeHTTP(w, r)
+// rateLimitID identifies the client for rate limiting: the network bucket
+// of its IP, falling back to the device_id cookie.
+func (app *application) rateLimitID(r *http.Request) string {
+	ip := clientIP(r)
+	if ip != "" {
+		ip = app.buckets.Bucket(ip)
 	}
-	return http.HandlerFunc(fn)
+	return security.GetRateLimitIdentifier(ip, getValFromContext(r.Context()))
+}
+
+// seconds rounds d up to whole seconds, as used by the rate limit headers.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
 }
 
 func (app *application) handleCookies(next http.Handler) http.Handler {
@@ -87,6 +126,41 @@ func (app *application) handleCookies(next http.Handler) http.Handler {
 	})
 }
 
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.cfg.adminToken)) != 1 {
+			app.unauthorizedError(w, errors.New("invalid admin token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// realIP resolves the client address once per request, honouring forwarding
+// headers only from trusted proxies. Rate limiting, moderation and audit
+// logs all read it back through clientIP.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := app.proxies.ClientIP(r)
+		r.RemoteAddr = ip
+
+		r = r.WithContext(context.WithValue(r.Context(), clientIPKey, ip))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 func getValFromContext(ctx context.Context) string {
 	switch v := ctx.Value(deviceIDKey).(type) {
 	case string:
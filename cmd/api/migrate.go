@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"versiy/cmd/migrate/migrations"
+	"versiy/internal/database"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var errMigrateUsage = errors.New("usage: versiy migrate up|down [n]|force <version>|status|version")
+
+// runMigrate implements the migrate subcommand against POSTGRES_ADDR.
+// down rolls back one migration unless a count is given. force marks a
+// version as cleanly applied after a failed migration was fixed by hand.
+func runMigrate(ctx context.Context, addr string, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	pool, err := database.NewDBConn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	migrator, err := database.NewMigrator(pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errMigrateUsage
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d migrations\n", reverted)
+	case "force":
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return errMigrateUsage
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+		fmt.Printf("forced version %d\n", version)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%06d_%-30s %s\n", s.Version, s.Name, state)
+		}
+	case "version":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+		} else {
+			fmt.Println(version)
+		}
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
+
+// migrateOnBoot applies pending migrations before the API starts. The
+// advisory lock makes replicas starting together wait for the first one.
+func migrateOnBoot(ctx context.Context, pool *pgxpool.Pool) error {
+	migrator, err := database.NewMigrator(pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("migrations: applied %d", applied)
+	return nil
+}
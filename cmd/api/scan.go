@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"versiy/internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// scanGuard protects the redirect route against enumeration of the short
+// code space. Every 404 counts as a miss for the client; past the allowed
+// misses per window the 404s are answered progressively slower, and past
+// blockAfter misses the client is blocked. Requesting a honeypot code
+// blocks the client immediately. Successful redirects are never delayed.
+func (app *application) scanGuard(next http.Handler) http.Handler {
+	cfg := app.cfg.scanning
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if app.buckets.Allowlisted(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := app.rateLimitID(r)
+
+		blockedFor, err := app.store.Scans.BlockedFor(ctx, id)
+		if err != nil {
+			log.Printf("scan guard: %v", err)
+		}
+		if blockedFor > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(blockedFor)))
+			app.tooManyRequestsError(w, errors.New("too many unknown short codes requested"))
+			return
+		}
+
+		if _, ok := cfg.honeypots[chi.URLParam(r, "code")]; ok {
+			log.Printf("scan guard: honeypot code requested by %s", id)
+			if err := app.store.Scans.Block(ctx, id, cfg.blockFor); err != nil {
+				log.Printf("scan guard: %v", err)
+			}
+			app.notFoundError(w)
+			return
+		}
+
+		next.ServeHTTP(&missRecorder{
+			ResponseWriter: w,
+			onNotFound: func() {
+				app.recordMiss(ctx, id)
+			},
+		}, r)
+	})
+}
+
+// checkHoneypots refuses honeypot codes that already belong to a link, since
+// every visitor of that link would be blocked.
+func checkHoneypots(ctx context.Context, links database.LinkRepository, honeypots []string) error {
+	for _, code := range honeypots {
+		_, err := links.Get(ctx, code)
+		if err == nil {
+			return fmt.Errorf("honeypot code %q is already used by a link", code)
+		}
+		if err != database.ErrLinkNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *application) recordMiss(ctx context.Context, id string) {
+	cfg := app.cfg.scanning
+
+	misses, err := app.store.Scans.RecordMiss(ctx, id, cfg.misses.window)
+	if err != nil {
+		log.Printf("scan guard: %v", err)
+		return
+	}
+
+	if misses >= cfg.blockAfter {
+		log.Printf("scan guard: blocking %s after %d misses", id, misses)
+		if err := app.store.Scans.Block(ctx, id, cfg.blockFor); err != nil {
+			log.Printf("scan guard: %v", err)
+		}
+	}
+
+	if misses <= cfg.misses.limit {
+		return
+	}
+
+	delay := min(time.Duration(misses-cfg.misses.limit)*cfg.delayStep, cfg.maxDelay)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
+// missRecorder calls onNotFound before a 404 response is written.
+type missRecorder struct {
+	http.ResponseWriter
+	onNotFound  func()
+	wroteHeader bool
+}
+
+func (m *missRecorder) WriteHeader(statusCode int) {
+	if !m.wroteHeader && statusCode == http.StatusNotFound {
+		m.onNotFound()
+	}
+	m.wroteHeader = true
+	m.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (m *missRecorder) Write(b []byte) (int, error) {
+	if !m.wroteHeader {
+		m.WriteHeader(http.StatusOK)
+	}
+	return m.ResponseWriter.Write(b)
+}
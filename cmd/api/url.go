@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"log"
 	"net/http"
 	"net/url"
 	"time"
@@ -10,9 +11,12 @@ import (
 	"versiy/internal/security"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v5"
 )
 
+// errLinkDisabled is all a visitor learns about a disabled link. The admin
+// reason may hold moderation notes or abuse report details.
+var errLinkDisabled = errors.New("link has been disabled")
+
 func (app *application) StoreURL(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		OriginalURL string `json:"original_url" validate:"required,url"`
@@ -36,17 +40,53 @@ func (app *application) StoreURL(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
 	defer cancel()
 
+	blocked, err := app.store.Moderation.IsBlocked(ctx, clientIP(r), getValFromContext(r.Context()))
+	if err != nil {
+		app.internalServerError(w, err)
+		return
+	}
+	if blocked {
+		app.forbiddenError(w, errors.New("link creation is blocked for this client"))
+		return
+	}
+
+	domain, err := security.RegistrableDomain(validatedURL)
+	if err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	review, reserved, err := app.throttleDomain(ctx, domain)
+	if err != nil {
+		app.forbiddenError(w, err)
+		return
+	}
+
 	defaultExpiry := time.Now().Add(time.Hour * 24 * 30)
 
-	shortCode, err := app.store.URL.Store(ctx, database.URLInsert{
+	shortCode, created, err := app.store.Links.Store(ctx, database.URLInsert{
 		OriginalURL: validatedURL,
 		ExpiresAt:   &defaultExpiry,
-	}, app.cfg.secret)
+		DeviceID:    getValFromContext(r.Context()),
+		Review:      review,
+	})
+	if reserved && (err != nil || !created) {
+		app.releaseCreation(ctx, domain)
+	}
 	if err != nil {
-		app.badRequest(w, err)
+		app.internalServerError(w, err)
 		return
 	}
 
+	// A new code may have been looked up before it existed and cached as
+	// not found. Existing codes are left alone so that repeated requests
+	// cannot evict a hot link from every instance's cache.
+	if created {
+		if err := app.store.Cache.Invalidate(ctx, shortCode); err != nil {
+			log.Printf("cache invalidation: %v", err)
+		}
+	}
+
 	if err := encodeJSON(w, map[string]string{
 		"url": app.cfg.defaultLink + shortCode,
 	}, http.StatusCreated); err != nil {
@@ -67,33 +107,30 @@ func (app *application) GetURL(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
 	defer cancel()
 
-	cachedURL, err := app.store.URL.CheckCached(ctx, shortCode)
-	if err == nil && cachedURL != "" {
-		if err := app.store.URL.UpdateClicks(ctx, shortCode); err != nil {
-			app.internalServerError(w, err)
-			return
-		}
-		if err := app.store.URL.LastTimeAccessed(ctx, shortCode); err != nil {
+	link, err := app.store.Cache.CheckCached(ctx, shortCode)
+	if err != nil {
+		link, err = app.store.Resolver.Load(ctx, shortCode, app.cfg.redisConfig.defualtTTL)
+		if err != nil {
 			app.internalServerError(w, err)
 			return
 		}
-		http.Redirect(w, r, cachedURL, http.StatusFound)
-		return
 	}
 
-	originalURL, err := app.store.URL.Get(ctx, shortCode)
-
-	if err != nil {
-		switch err {
-		case pgx.ErrNoRows:
-			app.notFoundError(w)
-		default:
-			app.badRequest(w, err)
+	switch link.Status {
+	case database.LinkActive:
+	case database.LinkDisabled:
+		status := link.DisabledStatus
+		if status == 0 {
+			status = http.StatusGone
 		}
+		responseError(w, errLinkDisabled, status)
+		return
+	default:
+		app.notFoundError(w)
 		return
 	}
 
-	u, err := url.Parse(originalURL)
+	u, err := url.Parse(link.Destination)
 	if err != nil || !u.IsAbs() {
 		app.badRequest(w, errors.New("invalid redirect url"))
 		return
@@ -104,9 +141,9 @@ func (app *application) GetURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := app.store.URL.LastTimeAccessed(ctx, shortCode); err != nil {
+	if err := app.store.Links.LastTimeAccessed(ctx, shortCode); err != nil {
 		switch err {
-		case pgx.ErrNoRows:
+		case database.ErrLinkNotFound:
 			app.notFoundError(w)
 		default:
 			app.internalServerError(w, err)
@@ -114,15 +151,16 @@ func (app *application) GetURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := app.store.URL.UpdateClicks(ctx, shortCode); err != nil {
+	if err := app.store.Clicks.UpdateClicks(ctx, shortCode); err != nil {
 		app.internalServerError(w, err)
 		return
 	}
 
-	if err = app.store.URL.CacheResult(ctx, shortCode, originalURL, app.cfg.redisConfig.defualtTTL); err != nil {
-		app.internalServerError(w, err)
-		return
-	}
+	app.store.Hot.Track(shortCode)
 
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	redirectStatus := link.RedirectStatus
+	if redirectStatus == 0 {
+		redirectStatus = http.StatusFound
+	}
+	http.Redirect(w, r, link.Destination, redirectStatus)
 }
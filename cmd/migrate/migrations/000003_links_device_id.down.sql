@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS links_device_id_idx;
+ALTER TABLE links DROP COLUMN IF EXISTS device_id;
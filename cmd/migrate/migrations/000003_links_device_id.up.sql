@@ -0,0 +1,2 @@
+ALTER TABLE links ADD COLUMN IF NOT EXISTS device_id UUID;
+CREATE INDEX IF NOT EXISTS links_device_id_idx ON links(device_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS blocked_creators;
+ALTER TABLE links DROP COLUMN IF EXISTS disabled_status;
+ALTER TABLE links DROP COLUMN IF EXISTS disabled_reason;
+ALTER TABLE links DROP COLUMN IF EXISTS disabled_at;
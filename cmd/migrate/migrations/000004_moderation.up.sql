@@ -0,0 +1,21 @@
+ALTER TABLE links ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS disabled_reason VARCHAR;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS disabled_status INTEGER;
+
+CREATE TABLE IF NOT EXISTS blocked_creators(
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    value VARCHAR NOT NULL,
+    reason VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log(
+    id SERIAL PRIMARY KEY,
+    action VARCHAR NOT NULL,
+    actor VARCHAR NOT NULL,
+    target VARCHAR NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS link_reviews;
+ALTER TABLE links DROP COLUMN IF EXISTS held_at;
@@ -0,0 +1,12 @@
+ALTER TABLE links ADD COLUMN IF NOT EXISTS held_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS link_reviews(
+    id SERIAL PRIMARY KEY,
+    link_id INTEGER UNIQUE NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    domain VARCHAR NOT NULL,
+    reason VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS link_reviews_pending_idx ON link_reviews(created_at) WHERE resolved_at IS NULL;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS hot_links;
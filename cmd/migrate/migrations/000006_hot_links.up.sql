@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS hot_links(
+    short_code VARCHAR PRIMARY KEY,
+    score DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
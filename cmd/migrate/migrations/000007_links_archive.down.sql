@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS links_expires_at_idx;
+DROP TABLE IF EXISTS links_archive;
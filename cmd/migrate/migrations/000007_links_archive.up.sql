@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS links_archive(
+    id INTEGER PRIMARY KEY,
+    original_url VARCHAR NOT NULL,
+    short_code VARCHAR,
+    created_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    last_time_accessed TIMESTAMP,
+    device_id UUID,
+    disabled_at TIMESTAMP,
+    disabled_reason VARCHAR,
+    disabled_status INTEGER,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    archived_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS links_expires_at_idx ON links(expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS links_original_url_idx;
+ALTER TABLE links ALTER COLUMN short_code DROP NOT NULL;
@@ -0,0 +1,4 @@
+DELETE FROM links WHERE short_code IS NULL;
+ALTER TABLE links ALTER COLUMN short_code SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS links_original_url_idx ON links(original_url, created_at);
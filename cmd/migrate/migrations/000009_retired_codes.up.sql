@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS retired_codes(
+    short_code VARCHAR PRIMARY KEY,
+    retired_at TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO retired_codes (short_code)
+SELECT short_code FROM links_archive WHERE short_code IS NOT NULL
+ON CONFLICT (short_code) DO NOTHING;
@@ -0,0 +1,8 @@
+// Package migrations embeds the PostgreSQL schema migrations so that the
+// API binary can apply them without the migrate CLI.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
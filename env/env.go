@@ -1,13 +1,20 @@
 package env
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// init loads .env when there is one. Without it the variables come from
+// the environment alone, as in tests and containers.
 func init() {
-	if err := godotenv.Load(); err != nil {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic(err)
 	}
 }
@@ -19,3 +26,39 @@ func GetString(key, defult string) string {
 	}
 	return val
 }
+
+func GetInt(key string, defult int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return defult
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		panic(fmt.Sprintf("%s must be an integer, got %q", key, val))
+	}
+	return n
+}
+
+func GetDuration(key string, defult time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return defult
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		panic(fmt.Sprintf("%s must be a duration such as 15s or 5m, got %q", key, val))
+	}
+	return d
+}
+
+func GetBool(key string, defult bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return defult
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		panic(fmt.Sprintf("%s must be true or false, got %q", key, val))
+	}
+	return b
+}
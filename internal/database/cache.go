@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// RedisCache is the Cache shared by all instances, with an optional
+// in-process tier in front of it.
+type RedisCache struct {
+	redisClient redis.UniversalClient
+	local       *LocalCache
+	reads       *Replicas
+}
+
+// CacheResult stores the link in Redis and the local tier. Active links are
+// never cached past their expiry.
+func (rc *RedisCache) CacheResult(ctx context.Context, shortCode string, link Link, TTL time.Duration) error {
+	TTL = cacheTTL(link, TTL)
+	if TTL <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+
+	if err := rc.redisClient.Set(ctx, linkKey(shortCode), value, TTL).Err(); err != nil {
+		return err
+	}
+	rc.local.Set(shortCode, link, TTL)
+	return nil
+}
+
+// CheckCached looks the short code up in the local cache, then in Redis.
+func (rc *RedisCache) CheckCached(ctx context.Context, shortCode string) (Link, error) {
+	if link, ok := rc.local.Get(shortCode); ok {
+		return notFoundIfExpired(link), nil
+	}
+
+	value, err := rc.redisClient.Get(ctx, linkKey(shortCode)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			redisCacheMisses.Add(1)
+			return Link{}, ErrCacheMiss
+		}
+		return Link{}, err
+	}
+	redisCacheHits.Add(1)
+
+	var link Link
+	if err := json.Unmarshal(value, &link); err != nil {
+		return Link{}, err
+	}
+
+	if link.Status == LinkActive {
+		rc.local.Set(shortCode, link, time.Until(link.ExpiresAt))
+	} else if ttl, err := rc.redisClient.PTTL(ctx, linkKey(shortCode)).Result(); err == nil && ttl > 0 {
+		// Keep the local copy of a negative entry no longer than Redis does
+		rc.local.Set(shortCode, link, ttl)
+	}
+	return notFoundIfExpired(link), nil
+}
+
+// Invalidate removes cached redirects from Redis and tells every instance
+// to drop them from its local cache. With replicas, another instance may
+// still read the old row from a lagging replica and cache it again, so the
+// codes are invalidated a second time once the replicas have caught up.
+func (rc *RedisCache) Invalidate(ctx context.Context, shortCodes ...string) error {
+	if len(shortCodes) == 0 {
+		return nil
+	}
+
+	if err := rc.invalidate(ctx, shortCodes); err != nil {
+		return err
+	}
+
+	if delay := rc.reads.staleFor(); delay > 0 {
+		time.AfterFunc(delay, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := rc.invalidate(ctx, shortCodes); err != nil {
+				log.Printf("cache invalidation: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+func (rc *RedisCache) invalidate(ctx context.Context, shortCodes []string) error {
+	pipe := rc.redisClient.Pipeline()
+	for _, code := range shortCodes {
+		pipe.Del(ctx, linkKey(code))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	if err := rc.redisClient.Publish(ctx, invalidationChannel, strings.Join(shortCodes, " ")).Err(); err != nil {
+		log.Printf("cache invalidation: %v", err)
+	}
+	return nil
+}
+
+func (rc *RedisCache) AcquireFillLock(ctx context.Context, shortCode string, TTL time.Duration) (bool, error) {
+	return rc.redisClient.SetNX(ctx, fillLockKey(shortCode), 1, TTL).Result()
+}
+
+// memoryCache is the Cache of the memory backend. It only has the local
+// tier, so with the local cache disabled every lookup is a miss.
+type memoryCache struct {
+	local *LocalCache
+}
+
+func (mc *memoryCache) CacheResult(ctx context.Context, shortCode string, link Link, TTL time.Duration) error {
+	if TTL = cacheTTL(link, TTL); TTL > 0 {
+		mc.local.Set(shortCode, link, TTL)
+	}
+	return nil
+}
+
+func (mc *memoryCache) CheckCached(ctx context.Context, shortCode string) (Link, error) {
+	link, ok := mc.local.Get(shortCode)
+	if !ok {
+		return Link{}, ErrCacheMiss
+	}
+	return notFoundIfExpired(link), nil
+}
+
+func (mc *memoryCache) Invalidate(ctx context.Context, shortCodes ...string) error {
+	mc.local.Delete(shortCodes...)
+	return nil
+}
+
+// AcquireFillLock always succeeds; concurrent loads within the process are
+// already coalesced by the Resolver.
+func (mc *memoryCache) AcquireFillLock(ctx context.Context, shortCode string, TTL time.Duration) (bool, error) {
+	return true, nil
+}
+
+// cacheTTL caps TTL at the expiry of an active link.
+func cacheTTL(link Link, TTL time.Duration) time.Duration {
+	if link.Status == LinkActive && !link.ExpiresAt.IsZero() {
+		return min(TTL, time.Until(link.ExpiresAt))
+	}
+	return TTL
+}
+
+func notFoundIfExpired(link Link) Link {
+	if link.Expired() {
+		return Link{Status: LinkNotFound}
+	}
+	return link
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheFillFailures counts links loaded from the repository that could not
+// be cached. They are still served.
+var cacheFillFailures = expvar.NewInt("cache_fill_failures_total")
+
+const (
+	loadTimeout      = 5 * time.Second
+	fillPollInterval = 25 * time.Millisecond
+)
+
+// Resolver loads links that missed the Cache from the LinkRepository.
+type Resolver struct {
+	links       LinkRepository
+	cache       Cache
+	lookups     singleflight.Group
+	fillLockTTL time.Duration
+	notFoundTTL time.Duration
+}
+
+func NewResolver(links LinkRepository, cache Cache, fillLockTTL, notFoundTTL time.Duration) *Resolver {
+	return &Resolver{links: links, cache: cache, fillLockTTL: fillLockTTL, notFoundTTL: notFoundTTL}
+}
+
+// Load resolves a short code that missed the cache and caches the result,
+// including unknown and expired codes as LinkNotFound. Concurrent loads of
+// the same code within this instance share a single repository query. With
+// a fill lock configured, only the instance holding the short lock queries
+// the repository; the others wait for it to populate the cache and only
+// query themselves if it does not in time.
+func (rs *Resolver) Load(ctx context.Context, shortCode string, TTL time.Duration) (Link, error) {
+	ch := rs.lookups.DoChan(shortCode, func() (any, error) {
+		// Detach from the first caller so its cancellation does not fail
+		// every request waiting on the same code.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+
+		if rs.fillLockTTL > 0 {
+			if link, ok := rs.waitForFill(ctx, shortCode); ok {
+				return link, nil
+			}
+		}
+
+		link, err := rs.links.Get(ctx, shortCode)
+		if err == ErrLinkNotFound {
+			link, TTL = Link{Status: LinkNotFound}, rs.notFoundTTL
+			if TTL <= 0 {
+				return link, nil
+			}
+		} else if err != nil {
+			return Link{}, err
+		}
+
+		// The link is known at this point, so a cache write error must
+		// not fail the redirect.
+		if err := rs.cache.CacheResult(ctx, shortCode, link, TTL); err != nil {
+			cacheFillFailures.Add(1)
+			log.Printf("cache fill %s: %v", shortCode, err)
+		}
+		return link, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return Link{}, res.Err
+		}
+		return res.Val.(Link), nil
+	case <-ctx.Done():
+		return Link{}, ctx.Err()
+	}
+}
+
+// waitForFill takes the fill lock for the code. If another instance holds
+// it, the cache is polled until that instance fills it or the lock
+// expires. ok is false when the caller should query the repository itself.
+func (rs *Resolver) waitForFill(ctx context.Context, shortCode string) (Link, bool) {
+	acquired, err := rs.cache.AcquireFillLock(ctx, shortCode, rs.fillLockTTL)
+	if err != nil || acquired {
+		return Link{}, false
+	}
+
+	deadline := time.Now().Add(rs.fillLockTTL)
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(fillPollInterval):
+		case <-ctx.Done():
+			return Link{}, false
+		}
+
+		if link, err := rs.cache.CheckCached(ctx, shortCode); err == nil {
+			return link, true
+		}
+	}
+
+	return Link{}, false
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"errors"
+	"expvar"
+	"versiy/internal/util"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// maxCodeAttempts bounds how many codes are tried for one link before
+// Store gives up.
+const maxCodeAttempts = 5
+
+var errCodeTaken = errors.New("short code already taken")
+
+var shortCodeCollisions = expvar.NewInt("short_code_collisions_total")
+
+// assignCode asks the generator for codes until set stores one, retrying
+// when the code is reserved or set fails because it is already in use.
+func assignCode(codes util.CodeGenerator, id int64, set func(code string) error) (string, error) {
+	for attempt := 0; ; attempt++ {
+		code, err := codes.Generate(id, attempt)
+		if err == nil {
+			err = set(code)
+		}
+		if err == nil {
+			return code, nil
+		}
+		if !codeTaken(err) || attempt+1 >= maxCodeAttempts {
+			return "", err
+		}
+		shortCodeCollisions.Add(1)
+	}
+}
+
+// codeTaken reports whether err is a reserved code or a unique violation
+// from any backend.
+func codeTaken(err error) bool {
+	if errors.Is(err, errCodeTaken) || errors.Is(err, util.ErrCodeReserved) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
@@ -3,6 +3,10 @@ package database
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -36,15 +40,61 @@ func NewDBConn(ctx context.Context, addr string) (*pgxpool.Pool, error) {
 	return pool, nil
 }
 
-func NewRedisConn(ctx context.Context, addr string, psw string, dialTimeout, readTimeout, writeTimeout, poolTimeout time.Duration) *redis.Client {
-	options := &redis.Options{
-		Addr:                  addr,
-		Password:              psw,
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+type RedisOptions struct {
+	// Mode is one of RedisStandalone, RedisSentinel or RedisCluster.
+	Mode string
+	// Addr is either host:port or a redis:// or rediss:// URL carrying the
+	// username, password, database and TLS settings.
+	Addr     string
+	Password string
+	// Addrs lists the sentinels or the cluster seed nodes. For standalone
+	// mode it is ignored.
+	Addrs            []string
+	MasterName       string
+	SentinelPassword string
+
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+}
+
+func NewRedisConn(ctx context.Context, opts RedisOptions) (redis.UniversalClient, error) {
+	options, err := redisOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Mode {
+	case RedisSentinel:
+		return redis.NewFailoverClient(options.Failover()), nil
+	case RedisCluster:
+		return redis.NewClusterClient(options.Cluster()), nil
+	default:
+		return redis.NewClient(options.Simple()), nil
+	}
+}
+
+// redisOptions resolves the address, credentials and TLS settings of opts
+// and checks that they fit the mode, without connecting.
+func redisOptions(opts RedisOptions) (*redis.UniversalOptions, error) {
+	options := &redis.UniversalOptions{
+		Password:              opts.Password,
 		DB:                    0,
-		DialTimeout:           dialTimeout,
-		ReadTimeout:           readTimeout,
-		WriteTimeout:          writeTimeout,
-		PoolTimeout:           poolTimeout,
+		DialTimeout:           opts.DialTimeout,
+		ReadTimeout:           opts.ReadTimeout,
+		WriteTimeout:          opts.WriteTimeout,
+		PoolTimeout:           opts.PoolTimeout,
 		PoolSize:              10,
 		MinIdleConns:          5,
 		MaxIdleConns:          20,
@@ -52,14 +102,90 @@ func NewRedisConn(ctx context.Context, addr string, psw string, dialTimeout, rea
 		ConnMaxIdleTime:       30 * time.Minute,
 		ConnMaxLifetime:       0,
 		ContextTimeoutEnabled: true,
+		MasterName:            opts.MasterName,
+		SentinelPassword:      opts.SentinelPassword,
 	}
 
-	if strings.HasPrefix(addr, "rediss://") {
-		options.TLSConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
+	addr := opts.Addr
+	if strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") {
+		parsed, err := redis.ParseURL(addr)
+		if err != nil {
+			return nil, err
+		}
+		addr = parsed.Addr
+		options.Username = parsed.Username
+		if parsed.Password != "" {
+			options.Password = parsed.Password
+		}
+		options.DB = parsed.DB
+		options.TLSConfig = parsed.TLSConfig
+	}
+
+	tlsConfig, err := redisTLSConfig(options.TLSConfig, opts)
+	if err != nil {
+		return nil, err
+	}
+	options.TLSConfig = tlsConfig
+
+	switch opts.Mode {
+	case RedisStandalone, "":
+		options.Addrs = []string{addr}
+	case RedisSentinel:
+		if len(opts.Addrs) == 0 || opts.MasterName == "" {
+			return nil, errors.New("sentinel mode requires sentinel addresses and a master name")
+		}
+		options.Addrs = opts.Addrs
+	case RedisCluster:
+		options.Addrs = opts.Addrs
+		if len(options.Addrs) == 0 {
+			options.Addrs = []string{addr}
+		}
+		if options.DB != 0 {
+			return nil, errors.New("cluster mode only supports database 0")
+		}
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", opts.Mode)
+	}
+	return options, nil
+}
+
+// redisTLSConfig completes the TLS configuration from a rediss:// URL with
+// a custom CA and client certificate. Providing any of the files enables
+// TLS even when the address is not a rediss:// URL. The certificate and key
+// files must be set together.
+func redisTLSConfig(base *tls.Config, opts RedisOptions) (*tls.Config, error) {
+	if (opts.TLSCertFile == "") != (opts.TLSKeyFile == "") {
+		return nil, errors.New("redis TLS client certificate and key files must be set together")
+	}
+	if base == nil && opts.TLSCAFile == "" && opts.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.MinVersion = tls.VersionTLS12
+
+	if opts.TLSCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, err
 		}
+		config.Certificates = []tls.Certificate{cert}
 	}
 
-	rdb := redis.NewClient(options)
-	return rdb
+	return config, nil
 }
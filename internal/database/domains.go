@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrReviewNotFound = errors.New("review not found")
+
+// Disabled reasons of links held for review and rejected in review. Holds
+// are tracked by held_at, never by the reason: approving a review only
+// enables the link while held_at is set, and every other disable clears it,
+// so a link disabled by an admin in the meantime stays disabled.
+const (
+	reviewHeldReason     = "link is pending review"
+	reviewRejectedReason = "link was rejected in review"
+)
+
+// ReviewHold queues a new link for review under Domain.
+type ReviewHold struct {
+	Domain string
+	Reason string
+}
+
+// heldState holds the disabled columns of a link created for review, all
+// nil for a live link. at is stored in both disabled_at and held_at.
+type heldState struct {
+	at     *time.Time
+	reason *string
+	status *int
+}
+
+func newHeldState() heldState {
+	now := time.Now()
+	reason := reviewHeldReason
+	status := http.StatusForbidden
+	return heldState{at: &now, reason: &reason, status: &status}
+}
+
+// DomainStore counts link creations per destination domain across all
+// clients and resolves the reviews of links held by Store.
+type DomainStore struct {
+	dbConn      *pgxpool.Pool
+	redisClient redis.UniversalClient
+}
+
+type LinkReview struct {
+	ID          int       `json:"id"`
+	ShortCode   string    `json:"short_code"`
+	OriginalURL string    `json:"original_url"`
+	Domain      string    `json:"domain"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// rollingCountScript approximates a sliding window with two fixed windows:
+// the previous window's count is weighted by how much of it still overlaps
+// the rolling window ending now. ARGV[2] is added to the current window
+// first, negative to give hits back without dropping below zero.
+var rollingCountScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+local current = math.floor(now / window)
+
+local key = KEYS[1] .. ':' .. current
+local n = tonumber(ARGV[2])
+local count = tonumber(redis.call('GET', key)) or 0
+if count + n < 0 then
+  n = -count
+end
+count = redis.call('INCRBY', key, n)
+redis.call('PEXPIRE', key, window * 2)
+
+local previous = tonumber(redis.call('GET', KEYS[1] .. ':' .. (current - 1))) or 0
+local elapsed = (now % window) / window
+return math.floor(previous * (1 - elapsed) + count)
+`)
+
+// ReserveCreation counts a link about to be created for domain and returns
+// the links created within the rolling window, including it. Checking and
+// counting in one step keeps a burst from many clients from all reading
+// the same count.
+func (ds *DomainStore) ReserveCreation(ctx context.Context, domain string, window time.Duration) (int, error) {
+	return rollingCountScript.Run(ctx, ds.redisClient, []string{domainKey(domain)}, window.Milliseconds(), 1).Int()
+}
+
+func (ds *DomainStore) ReleaseCreation(ctx context.Context, domain string, window time.Duration) error {
+	return rollingCountScript.Run(ctx, ds.redisClient, []string{domainKey(domain)}, window.Milliseconds(), -1).Err()
+}
+
+func (ds *DomainStore) PendingReviews(ctx context.Context, limit int) ([]LinkReview, error) {
+	rows, err := ds.dbConn.Query(ctx,
+		`SELECT r.id, l.short_code, l.original_url, r.domain, r.reason, r.created_at
+		 FROM link_reviews r
+		 JOIN links l ON l.id = r.link_id
+		 WHERE r.resolved_at IS NULL
+		 ORDER BY r.created_at
+		 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[LinkReview])
+}
+
+// ResolveReview closes the review and enables the held link when approve
+// is set, or disables it for good otherwise. The audit entry is written in
+// the same transaction. It returns the code of the link.
+func (ds *DomainStore) ResolveReview(ctx context.Context, id int, approve bool, actor string) (string, error) {
+	tx, err := ds.dbConn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var linkID int64
+	var shortCode string
+	err = tx.QueryRow(ctx,
+		`UPDATE link_reviews r SET resolved_at = $1
+		 FROM links l
+		 WHERE r.id = $2 AND r.resolved_at IS NULL AND l.id = r.link_id
+		 RETURNING l.id, l.short_code`,
+		time.Now(),
+		id,
+	).Scan(&linkID, &shortCode)
+	if err == pgx.ErrNoRows {
+		return "", ErrReviewNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	action := "review.approve"
+	if approve {
+		_, err = tx.Exec(ctx,
+			`UPDATE links SET disabled_at = NULL, disabled_reason = NULL, disabled_status = NULL, held_at = NULL
+			 WHERE id = $1 AND held_at IS NOT NULL`,
+			linkID,
+		)
+	} else {
+		action = "review.reject"
+		_, err = tx.Exec(ctx,
+			`UPDATE links SET disabled_at = $1, disabled_reason = $2, disabled_status = $3, held_at = NULL
+			 WHERE id = $4 AND held_at IS NOT NULL`,
+			time.Now(),
+			reviewRejectedReason,
+			http.StatusGone,
+			linkID,
+		)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := audit(ctx, tx, action, actor, shortCode, map[string]any{
+		"review": id,
+	}); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	return shortCode, nil
+}
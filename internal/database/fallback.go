@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// FallbackOpen keeps limiting with an approximate per-instance limiter
+	// while Redis is unavailable.
+	FallbackOpen = "open"
+	// FallbackClosed rejects every limited request while Redis is unavailable.
+	FallbackClosed = "closed"
+)
+
+var ErrLimiterUnavailable = errors.New("rate limiter unavailable")
+
+var (
+	limiterMode      = expvar.NewString("ratelimit_mode")
+	limiterFailures  = expvar.NewInt("ratelimit_redis_failures_total")
+	limiterFallbacks = expvar.NewInt("ratelimit_fallback_requests_total")
+)
+
+// FallbackLimiter wraps the Redis limiter. When Redis fails it switches to
+// the configured fallback mode and probes Redis in the background until it
+// recovers.
+type FallbackLimiter struct {
+	primary  Limiter
+	local    *localLimiter
+	mode     string
+	ping     func(ctx context.Context) error
+	interval time.Duration
+	healthy  atomic.Bool
+}
+
+func NewFallbackLimiter(primary Limiter, mode string, ping func(ctx context.Context) error, interval time.Duration) (*FallbackLimiter, error) {
+	if mode != FallbackOpen && mode != FallbackClosed {
+		return nil, fmt.Errorf("unknown rate limit fallback mode %q", mode)
+	}
+
+	l := &FallbackLimiter{
+		primary:  primary,
+		local:    newLocalLimiter(),
+		mode:     mode,
+		ping:     ping,
+		interval: interval,
+	}
+	l.healthy.Store(true)
+	limiterMode.Set("redis")
+	return l, nil
+}
+
+func (l *FallbackLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	if l.healthy.Load() {
+		res, err := l.primary.Allow(ctx, key, limit, window)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return RateLimitResult{}, err
+		}
+		l.markUnhealthy(err)
+	}
+
+	limiterFallbacks.Add(1)
+	if l.mode == FallbackClosed {
+		return RateLimitResult{}, ErrLimiterUnavailable
+	}
+	return l.local.Allow(ctx, key, limit, window)
+}
+
+func (l *FallbackLimiter) markUnhealthy(err error) {
+	limiterFailures.Add(1)
+	if !l.healthy.CompareAndSwap(true, false) {
+		return
+	}
+
+	log.Printf("rate limiter: redis unavailable, failing %s: %v", l.mode, err)
+	limiterMode.Set(l.mode)
+	go l.probe()
+}
+
+func (l *FallbackLimiter) probe() {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+		err := l.ping(ctx)
+		cancel()
+		if err == nil {
+			log.Printf("rate limiter: redis recovered")
+			limiterMode.Set("redis")
+			l.healthy.Store(true)
+			return
+		}
+	}
+}
+
+// localLimiter is an in-process fixed window limiter. Limits only hold per
+// instance, so with several replicas a client may get up to limit requests
+// from each of them.
+type localLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*localWindow
+	lastSweep time.Time
+}
+
+type localWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+func newLocalLimiter() *localLimiter {
+	return &localLimiter{windows: map[string]*localWindow{}, lastSweep: time.Now()}
+}
+
+func (l *localLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	count, reset := l.incr(key, window)
+	result := RateLimitResult{
+		Allowed:    count <= limit,
+		Limit:      limit,
+		Remaining:  max(limit-count, 0),
+		ResetAfter: reset,
+	}
+	if !result.Allowed {
+		result.RetryAfter = result.ResetAfter
+	}
+	return result, nil
+}
+
+// incr counts a hit for key in its current window and returns the count
+// and the time until the window resets.
+func (l *localLimiter) incr(key string, window time.Duration) (int, time.Duration) {
+	return l.add(key, window, 1)
+}
+
+// add adds n hits for key, negative to give hits back. The count never
+// drops below zero.
+func (l *localLimiter) add(key string, window time.Duration, n int) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, w := range l.windows {
+			if now.After(w.resetAt) {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.After(w.resetAt) {
+		w = &localWindow{resetAt: now.Add(window)}
+		l.windows[key] = w
+	}
+	w.count = max(w.count+n, 0)
+	return w.count, w.resetAt.Sub(now)
+}
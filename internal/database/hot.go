@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// hotDecay halves every score once per maintenance period so that the
+	// list follows current traffic rather than all-time totals.
+	hotDecay = 0.5
+	// hotMaintenanceEvery is the number of flushes between two decay and
+	// persist runs.
+	hotMaintenanceEvery = 10
+	// hotKeep bounds the sorted set to a multiple of the tracked size.
+	hotKeep = 10
+)
+
+var hotWarmed = expvar.NewInt("cache_warmup_links_total")
+
+// HotStore tracks the most requested short codes. Hits are counted in
+// process and flushed to a Redis sorted set shared by all instances; the
+// top of that set is persisted to PostgreSQL so it survives a Redis
+// restart and is used to warm the caches on startup.
+type HotStore struct {
+	dbConn      *pgxpool.Pool
+	redisClient redis.UniversalClient
+	resolver    *Resolver
+	cache       Cache
+	size        int
+	interval    time.Duration
+
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+type HotLink struct {
+	ShortCode string  `json:"short_code"`
+	Score     float64 `json:"score"`
+}
+
+// Track counts a hit for the short code. It never blocks on Redis.
+func (hs *HotStore) Track(shortCode string) {
+	if hs.size <= 0 {
+		return
+	}
+
+	hs.mu.Lock()
+	hs.pending[shortCode]++
+	hs.mu.Unlock()
+}
+
+// Top returns the hottest codes, falling back to the persisted list when
+// Redis has none. It is empty when n is not positive.
+func (hs *HotStore) Top(ctx context.Context, n int) ([]HotLink, error) {
+	if n <= 0 {
+		return []HotLink{}, nil
+	}
+
+	scores, err := hs.redisClient.ZRevRangeWithScores(ctx, hotLinksKey(), 0, int64(n-1)).Result()
+	if err == nil && len(scores) > 0 {
+		links := make([]HotLink, 0, len(scores))
+		for _, z := range scores {
+			links = append(links, HotLink{ShortCode: z.Member.(string), Score: z.Score})
+		}
+		return links, nil
+	}
+	if err != nil {
+		log.Printf("hot links: %v", err)
+	}
+
+	rows, err := hs.dbConn.Query(ctx,
+		"SELECT short_code, score FROM hot_links ORDER BY score DESC LIMIT $1",
+		n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[HotLink])
+}
+
+// Run flushes tracked hits every interval until ctx is done. Every few
+// flushes one instance decays the scores and persists the top of the list.
+func (hs *HotStore) Run(ctx context.Context) {
+	if hs.size <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(hs.interval)
+	defer ticker.Stop()
+
+	for tick := 1; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := hs.flush(ctx); err != nil {
+			log.Printf("hot links: %v", err)
+		}
+		if tick%hotMaintenanceEvery == 0 {
+			if err := hs.maintain(ctx); err != nil {
+				log.Printf("hot links: %v", err)
+			}
+		}
+	}
+}
+
+func (hs *HotStore) flush(ctx context.Context) error {
+	hs.mu.Lock()
+	pending := hs.pending
+	hs.pending = make(map[string]int, len(pending))
+	hs.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	pipe := hs.redisClient.Pipeline()
+	for code, hits := range pending {
+		pipe.ZIncrBy(ctx, hotLinksKey(), float64(hits), code)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (hs *HotStore) maintain(ctx context.Context) error {
+	period := hs.interval * hotMaintenanceEvery
+	acquired, err := hs.redisClient.SetNX(ctx, hotMaintenanceKey(), 1, period-hs.interval/2).Result()
+	if err != nil || !acquired {
+		return err
+	}
+
+	pipe := hs.redisClient.TxPipeline()
+	pipe.ZUnionStore(ctx, hotLinksKey(), &redis.ZStore{Keys: []string{hotLinksKey()}, Weights: []float64{hotDecay}})
+	pipe.ZRemRangeByRank(ctx, hotLinksKey(), 0, -int64(hs.size*hotKeep)-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	top, err := hs.Top(ctx, hs.size)
+	if err != nil {
+		return err
+	}
+
+	tx, err := hs.dbConn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM hot_links"); err != nil {
+		return err
+	}
+	rows := make([][]any, 0, len(top))
+	for _, link := range top {
+		rows = append(rows, []any{link.ShortCode, link.Score, time.Now()})
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"hot_links"}, []string{"short_code", "score", "updated_at"}, pgx.CopyFromRows(rows)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// WarmUp preloads the hottest links into Redis and the local cache.
+func (hs *HotStore) WarmUp(ctx context.Context, TTL time.Duration) {
+	if hs.size <= 0 {
+		return
+	}
+
+	top, err := hs.Top(ctx, hs.size)
+	if err != nil {
+		log.Printf("cache warm-up: %v", err)
+		return
+	}
+
+	for _, hot := range top {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := hs.cache.CheckCached(ctx, hot.ShortCode); err == nil {
+			hotWarmed.Add(1)
+			continue
+		}
+		if _, err := hs.resolver.Load(ctx, hot.ShortCode, TTL); err != nil {
+			log.Printf("cache warm-up: %s: %v", hot.ShortCode, err)
+			continue
+		}
+		hotWarmed.Add(1)
+	}
+	log.Printf("cache warm-up: preloaded %d hot links", len(top))
+}
@@ -0,0 +1,40 @@
+package database
+
+// Every Redis key is namespaced by purpose and prefixed with the keyspace
+// version. Bumping keyVersion lets the stored formats change without
+// flushing Redis: old keys are simply never read again and expire.
+const keyVersion = "v1"
+
+func linkKey(shortCode string) string {
+	return keyVersion + ":link:" + shortCode
+}
+
+func fillLockKey(shortCode string) string {
+	return keyVersion + ":fill:" + shortCode
+}
+
+func rateLimitKey(id string) string {
+	return keyVersion + ":rl:" + id
+}
+
+func scanMissKey(id string) string {
+	return keyVersion + ":scan:miss:" + id
+}
+
+func scanBlockKey(id string) string {
+	return keyVersion + ":scan:block:" + id
+}
+
+// domainKey uses a hash tag so the per-window counters derived from it in
+// rollingCountScript live in the same cluster slot.
+func domainKey(domain string) string {
+	return keyVersion + ":domain:{" + domain + "}"
+}
+
+func hotLinksKey() string {
+	return keyVersion + ":hot"
+}
+
+func hotMaintenanceKey() string {
+	return keyVersion + ":hot:maintenance"
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel carries short codes whose cached redirects must be
+// dropped by every instance.
+const invalidationChannel = keyVersion + ":links:invalidate"
+
+var (
+	localCacheHits   = expvar.NewInt("cache_local_hits_total")
+	localCacheMisses = expvar.NewInt("cache_local_misses_total")
+	redisCacheHits   = expvar.NewInt("cache_redis_hits_total")
+	redisCacheMisses = expvar.NewInt("cache_redis_misses_total")
+)
+
+// LocalCache is a bounded in-process LRU cache of resolved redirects that
+// sits in front of Redis. Entries live for a short TTL so that an instance
+// that misses an invalidation message serves stale data only briefly.
+// A nil *LocalCache is valid and caches nothing.
+type LocalCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type localEntry struct {
+	key       string
+	link      Link
+	expiresAt time.Time
+}
+
+// NewLocalCache returns a cache holding at most size entries, or nil when
+// size is zero.
+func NewLocalCache(size int, ttl time.Duration) *LocalCache {
+	if size <= 0 {
+		return nil
+	}
+	return &LocalCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func (c *LocalCache) Get(key string) (Link, bool) {
+	if c == nil {
+		return Link{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		localCacheMisses.Add(1)
+		return Link{}, false
+	}
+
+	entry := el.Value.(*localEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		localCacheMisses.Add(1)
+		return Link{}, false
+	}
+
+	c.order.MoveToFront(el)
+	localCacheHits.Add(1)
+	return entry.link, true
+}
+
+// Set stores the link for ttl, capped at the cache's own TTL.
+func (c *LocalCache) Set(key string, link Link, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(min(ttl, c.ttl))
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*localEntry)
+		entry.link = link
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&localEntry{key: key, link: link, expiresAt: expiresAt})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*localEntry).key)
+	}
+}
+
+func (c *LocalCache) Delete(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.order.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// ListenForInvalidations evicts short codes published by any instance from
+// the local cache until ctx is done. The codes are also read from the
+// primary for a while, since they were just written by another instance
+// and the replicas may not have caught up.
+func ListenForInvalidations(ctx context.Context, redisClient redis.UniversalClient, cache *LocalCache, reads *Replicas) {
+	sub := redisClient.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		codes := strings.Fields(msg.Payload)
+		if cache != nil {
+			cache.Delete(codes...)
+		}
+		reads.Wrote(codes...)
+	}
+}
@@ -0,0 +1,487 @@
+package database
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"versiy/internal/util"
+)
+
+// MemoryLinks is an in-process LinkRepository and ClickRecorder. It also
+// keeps the review queue, so that holding a link and queueing it happen
+// under one lock.
+type MemoryLinks struct {
+	codes  util.CodeGenerator
+	mu     sync.Mutex
+	nextID int64
+	links  []*memoryLink
+	byCode map[string]*memoryLink
+	// retired holds the codes of reaped links, which are never issued
+	// again.
+	retired map[string]struct{}
+
+	nextReviewID int
+	reviews      []*memoryReview
+}
+
+type memoryLink struct {
+	LinkSummary
+	disabledStatus int
+	heldAt         *time.Time
+	deviceID       string
+	lastAccessed   time.Time
+	clicks         int
+}
+
+func NewMemoryLinks(codes util.CodeGenerator) *MemoryLinks {
+	return &MemoryLinks{codes: codes, byCode: map[string]*memoryLink{}, retired: map[string]struct{}{}}
+}
+
+func (ml *MemoryLinks) Store(ctx context.Context, params URLInsert) (string, bool, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	now := time.Now()
+	for i := len(ml.links) - 1; i >= 0; i-- {
+		l := ml.links[i]
+		if l.OriginalURL == params.OriginalURL && (l.DisabledAt == nil || l.held()) && (l.ExpiresAt.IsZero() || l.ExpiresAt.After(now)) {
+			return l.ShortCode, false, nil
+		}
+	}
+
+	ml.nextID++
+	shortCode, err := assignCode(ml.codes, ml.nextID, func(code string) error {
+		if _, ok := ml.byCode[code]; ok {
+			return errCodeTaken
+		}
+		if _, ok := ml.retired[code]; ok {
+			return errCodeTaken
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	link := &memoryLink{
+		LinkSummary: LinkSummary{
+			ShortCode:   shortCode,
+			OriginalURL: params.OriginalURL,
+			CreatedAt:   now,
+		},
+		deviceID: params.DeviceID,
+	}
+	if params.ExpiresAt != nil {
+		link.ExpiresAt = *params.ExpiresAt
+	}
+
+	if params.Review != nil {
+		link.disable(reviewHeldReason, http.StatusForbidden)
+		link.heldAt = link.DisabledAt
+		ml.nextReviewID++
+		ml.reviews = append(ml.reviews, &memoryReview{LinkReview: LinkReview{
+			ID:          ml.nextReviewID,
+			ShortCode:   shortCode,
+			OriginalURL: params.OriginalURL,
+			Domain:      params.Review.Domain,
+			Reason:      params.Review.Reason,
+			CreatedAt:   now,
+		}})
+	}
+
+	ml.links = append(ml.links, link)
+	ml.byCode[link.ShortCode] = link
+	return link.ShortCode, true, nil
+}
+
+func (ml *MemoryLinks) Get(ctx context.Context, shortCode string) (Link, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	l, ok := ml.byCode[shortCode]
+	if !ok || (!l.ExpiresAt.IsZero() && time.Now().After(l.ExpiresAt)) {
+		return Link{}, ErrLinkNotFound
+	}
+
+	if l.DisabledAt != nil {
+		return Link{Status: LinkDisabled, DisabledStatus: l.disabledStatus}, nil
+	}
+	return Link{
+		Status:         LinkActive,
+		Destination:    l.OriginalURL,
+		RedirectStatus: http.StatusFound,
+		ExpiresAt:      l.ExpiresAt,
+	}, nil
+}
+
+func (ml *MemoryLinks) LastTimeAccessed(ctx context.Context, shortCode string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	l, ok := ml.byCode[shortCode]
+	if !ok {
+		return ErrLinkNotFound
+	}
+	l.lastAccessed = time.Now()
+	return nil
+}
+
+func (ml *MemoryLinks) UpdateClicks(ctx context.Context, shortCode string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	l, ok := ml.byCode[shortCode]
+	if !ok {
+		return ErrLinkNotFound
+	}
+	l.clicks++
+	return nil
+}
+
+func (ml *MemoryLinks) Disable(ctx context.Context, shortCode, reason string, status int, actor string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	l, ok := ml.byCode[shortCode]
+	if !ok {
+		return ErrLinkNotFound
+	}
+	l.disable(reason, status)
+	logAudit("link.disable", actor, shortCode, map[string]any{
+		"reason": reason,
+		"status": status,
+	})
+	return nil
+}
+
+func (ml *MemoryLinks) DisableDomain(ctx context.Context, domain, reason string, status int, actor string) ([]string, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	var codes []string
+	for _, l := range ml.links {
+		if l.DisabledAt != nil && !l.held() {
+			continue
+		}
+		if matchesDomain(l.OriginalURL, domain) {
+			l.disable(reason, status)
+			codes = append(codes, l.ShortCode)
+		}
+	}
+	logAudit("domain.disable", actor, domain, map[string]any{
+		"reason":   reason,
+		"status":   status,
+		"disabled": len(codes),
+	})
+	return codes, nil
+}
+
+func (ml *MemoryLinks) Search(ctx context.Context, destination string, limit int) ([]LinkSummary, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	destination = strings.ToLower(destination)
+	links := []LinkSummary{}
+	for i := len(ml.links) - 1; i >= 0 && len(links) < limit; i-- {
+		if strings.Contains(strings.ToLower(ml.links[i].OriginalURL), destination) {
+			links = append(links, ml.links[i].LinkSummary)
+		}
+	}
+	return links, nil
+}
+
+// Reap removes expired links. There is nowhere to archive them in
+// process, so archive is ignored.
+func (ml *MemoryLinks) Reap(ctx context.Context, cutoff time.Time, limit int, archive bool) (int, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	removed := 0
+	kept := ml.links[:0]
+	for _, l := range ml.links {
+		if removed < limit && !l.ExpiresAt.IsZero() && l.ExpiresAt.Before(cutoff) {
+			delete(ml.byCode, l.ShortCode)
+			ml.retired[l.ShortCode] = struct{}{}
+			removed++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	clear(ml.links[len(kept):])
+	ml.links = kept
+
+	reviews := ml.reviews[:0]
+	for _, r := range ml.reviews {
+		if _, ok := ml.byCode[r.ShortCode]; ok {
+			reviews = append(reviews, r)
+		}
+	}
+	clear(ml.reviews[len(reviews):])
+	ml.reviews = reviews
+	return removed, nil
+}
+
+func (ml *MemoryLinks) pendingReviews(limit int) []LinkReview {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	reviews := []LinkReview{}
+	for _, r := range ml.reviews {
+		if len(reviews) == limit {
+			break
+		}
+		if !r.resolved {
+			reviews = append(reviews, r.LinkReview)
+		}
+	}
+	return reviews
+}
+
+func (ml *MemoryLinks) resolveReview(id int, approve bool, actor string) (string, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	for _, r := range ml.reviews {
+		if r.ID != id || r.resolved {
+			continue
+		}
+		r.resolved = true
+
+		action := "review.approve"
+		if !approve {
+			action = "review.reject"
+		}
+		if l, ok := ml.byCode[r.ShortCode]; ok && l.held() {
+			if approve {
+				l.DisabledAt, l.DisabledReason, l.disabledStatus, l.heldAt = nil, nil, 0, nil
+			} else {
+				l.disable(reviewRejectedReason, http.StatusGone)
+			}
+		}
+		logAudit(action, actor, r.ShortCode, map[string]any{
+			"review": id,
+		})
+		return r.ShortCode, nil
+	}
+	return "", ErrReviewNotFound
+}
+
+// matchesDomain reports whether the URL's host is domain or one of its
+// subdomains.
+func matchesDomain(rawURL, domain string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// held reports whether the link is waiting for its review.
+func (l *memoryLink) held() bool {
+	return l.heldAt != nil
+}
+
+// disable also ends any hold, so that approving the review later cannot
+// enable the link again.
+func (l *memoryLink) disable(reason string, status int) {
+	now := time.Now()
+	l.DisabledAt = &now
+	l.DisabledReason = &reason
+	l.disabledStatus = status
+	l.heldAt = nil
+}
+
+// memoryHot ranks codes by hits in process. Scores decay like the Redis
+// list but are not persisted, so there is nothing to warm up on startup.
+type memoryHot struct {
+	size     int
+	interval time.Duration
+
+	mu     sync.Mutex
+	scores map[string]float64
+}
+
+func (mh *memoryHot) Track(shortCode string) {
+	if mh.size <= 0 {
+		return
+	}
+
+	mh.mu.Lock()
+	mh.scores[shortCode]++
+	mh.mu.Unlock()
+}
+
+func (mh *memoryHot) Top(ctx context.Context, n int) ([]HotLink, error) {
+	if n <= 0 {
+		return []HotLink{}, nil
+	}
+
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	return mh.top(n), nil
+}
+
+func (mh *memoryHot) top(n int) []HotLink {
+	links := make([]HotLink, 0, len(mh.scores))
+	for code, score := range mh.scores {
+		links = append(links, HotLink{ShortCode: code, Score: score})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Score > links[j].Score
+	})
+	return links[:min(n, len(links))]
+}
+
+func (mh *memoryHot) Run(ctx context.Context) {
+	if mh.size <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(mh.interval * hotMaintenanceEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		mh.mu.Lock()
+		kept := make(map[string]float64, mh.size*hotKeep)
+		for _, link := range mh.top(mh.size * hotKeep) {
+			kept[link.ShortCode] = link.Score * hotDecay
+		}
+		mh.scores = kept
+		mh.mu.Unlock()
+	}
+}
+
+func (mh *memoryHot) WarmUp(ctx context.Context, TTL time.Duration) {}
+
+// memoryScans tracks misses in fixed windows and blocks in process.
+type memoryScans struct {
+	misses *localLimiter
+
+	mu      sync.Mutex
+	blocked map[string]time.Time
+}
+
+func (ms *memoryScans) RecordMiss(ctx context.Context, id string, window time.Duration) (int, error) {
+	count, _ := ms.misses.incr(id, window)
+	return count, nil
+}
+
+func (ms *memoryScans) Block(ctx context.Context, id string, duration time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.blocked[id] = time.Now().Add(duration)
+	return nil
+}
+
+func (ms *memoryScans) Run(ctx context.Context) {}
+
+// prune drops the blocks that have expired.
+func (ms *memoryScans) prune() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	for id, until := range ms.blocked {
+		if now.After(until) {
+			delete(ms.blocked, id)
+		}
+	}
+}
+
+func (ms *memoryScans) BlockedFor(ctx context.Context, id string) (time.Duration, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	until, ok := ms.blocked[id]
+	if !ok {
+		return 0, nil
+	}
+	if left := time.Until(until); left > 0 {
+		return left, nil
+	}
+	delete(ms.blocked, id)
+	return 0, nil
+}
+
+// memoryDomains counts creations per domain in fixed windows rather than
+// the rolling window used with Redis. The review queue is kept by
+// MemoryLinks.
+type memoryDomains struct {
+	creations *localLimiter
+	links     *MemoryLinks
+}
+
+type memoryReview struct {
+	LinkReview
+	resolved bool
+}
+
+func (md *memoryDomains) ReserveCreation(ctx context.Context, domain string, window time.Duration) (int, error) {
+	count, _ := md.creations.add(domain, window, 1)
+	return count, nil
+}
+
+func (md *memoryDomains) ReleaseCreation(ctx context.Context, domain string, window time.Duration) error {
+	md.creations.add(domain, window, -1)
+	return nil
+}
+
+func (md *memoryDomains) PendingReviews(ctx context.Context, limit int) ([]LinkReview, error) {
+	return md.links.pendingReviews(limit), nil
+}
+
+func (md *memoryDomains) ResolveReview(ctx context.Context, id int, approve bool, actor string) (string, error) {
+	return md.links.resolveReview(id, approve, actor)
+}
+
+// memoryModeration keeps blocked creators in process and writes the audit
+// log to the standard logger.
+type memoryModeration struct {
+	mu      sync.Mutex
+	blocked map[string]string
+}
+
+func (mm *memoryModeration) BlockCreator(ctx context.Context, kind, value, reason, actor string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.blocked[kind+":"+value] = reason
+	logAudit("creator.block", actor, kind+":"+value, map[string]any{
+		"reason": reason,
+	})
+	return nil
+}
+
+func (mm *memoryModeration) IsBlocked(ctx context.Context, ip, deviceID string) (bool, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	_, byIP := mm.blocked[BlockKindIP+":"+ip]
+	_, byDevice := mm.blocked[BlockKindDeviceID+":"+deviceID]
+	return byIP || byDevice, nil
+}
+
+func (mm *memoryModeration) Audit(ctx context.Context, action, actor, target string, details map[string]any) error {
+	logAudit(action, actor, target, details)
+	return nil
+}
+
+// logAudit writes an audit entry to the standard logger, for backends
+// without an audit table.
+func logAudit(action, actor, target string, details map[string]any) {
+	log.Printf("audit: %s by %s on %s %v", action, actor, target, details)
+}
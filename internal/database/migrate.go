@@ -0,0 +1,295 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	migrationsTable = "schema_migrations"
+	// nilVersion is how golang-migrate records that no migration is applied.
+	nilVersion = -1
+	// advisoryLockSalt matches golang-migrate so that the CLI and the
+	// Migrator exclude each other.
+	advisoryLockSalt uint32 = 1486364155
+)
+
+var ErrDirtyDatabase = errors.New("database is dirty")
+
+// Migrator applies SQL migrations named <version>_<title>.up.sql and
+// <version>_<title>.down.sql to PostgreSQL. It keeps its state in the
+// schema_migrations table and holds the advisory lock used by
+// golang-migrate, so it can be used alongside the migrate CLI.
+type Migrator struct {
+	dbConn     *pgxpool.Pool
+	migrations []migration
+}
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+func NewMigrator(conn *pgxpool.Pool, files fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		direction := ""
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, title, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version", name)
+		}
+
+		query, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: strings.TrimSuffix(title, "."+direction+".sql")}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(query)
+		} else {
+			m.down = string(query)
+		}
+	}
+
+	migrator := &Migrator{dbConn: conn}
+	for _, m := range byVersion {
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].version < migrator.migrations[j].version
+	})
+	return migrator, nil
+}
+
+// Up applies every pending migration and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		current, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if mig.version <= current {
+				continue
+			}
+			if err := m.run(ctx, conn, mig.up, mig.version); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.version, mig.name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back up to steps migrations and returns how many were rolled
+// back.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		current, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mig := m.migrations[i]
+			if mig.version > current {
+				continue
+			}
+
+			target := nilVersion
+			if i > 0 {
+				target = m.migrations[i-1].version
+			}
+			if err := m.run(ctx, conn, mig.down, target); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.version, mig.name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Force records version as the current clean version without running any
+// migration, to recover from a dirty database once the schema has been
+// fixed by hand. Zero records that no migration is applied.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	target := nilVersion
+	if version != 0 {
+		known := false
+		for _, mig := range m.migrations {
+			known = known || mig.version == version
+		}
+		if !known {
+			return fmt.Errorf("unknown migration version %d", version)
+		}
+		target = version
+	}
+
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		return setVersion(ctx, conn, target, false)
+	})
+}
+
+// Version returns the current schema version, zero when no migration has
+// been applied, and whether the last migration failed halfway.
+func (m *Migrator) Version(ctx context.Context) (int, bool, error) {
+	conn, err := m.dbConn.Acquire(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Release()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return 0, false, err
+	}
+	version, dirty, err := readVersion(ctx, conn)
+	return max(version, 0), dirty, err
+}
+
+// Status lists every known migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	version, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status = append(status, MigrationStatus{Version: mig.version, Name: mig.name, Applied: mig.version <= version})
+	}
+	return status, nil
+}
+
+// locked runs fn on a single connection holding the migration advisory
+// lock, waiting for any other instance or CLI run to finish first.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.dbConn.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var database, schema string
+	if err := conn.QueryRow(ctx, "SELECT current_database(), current_schema()").Scan(&database, &schema); err != nil {
+		return err
+	}
+	lockID := advisoryLockID(database, schema, migrationsTable)
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return err
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockID)
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// cleanVersion returns the current version, or ErrDirtyDatabase when a
+// previous run failed partway and the schema needs fixing by hand.
+func (m *Migrator) cleanVersion(ctx context.Context, conn *pgxpool.Conn) (int, error) {
+	version, dirty, err := readVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w at version %d: fix the schema, then run \"versiy migrate force <version>\" with the last version that is fully applied", ErrDirtyDatabase, version)
+	}
+	return version, nil
+}
+
+// run executes a migration the way golang-migrate does: the target version
+// is recorded as dirty first and only marked clean once the SQL succeeded.
+func (m *Migrator) run(ctx context.Context, conn *pgxpool.Conn, query string, target int) error {
+	if err := setVersion(ctx, conn, target, true); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	return setVersion(ctx, conn, target, false)
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *pgxpool.Conn) error {
+	_, err := conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+migrationsTable+" (version bigint not null primary key, dirty boolean not null)")
+	return err
+}
+
+func readVersion(ctx context.Context, conn *pgxpool.Conn) (int, bool, error) {
+	var version int
+	var dirty bool
+	err := conn.QueryRow(ctx, "SELECT version, dirty FROM "+migrationsTable+" LIMIT 1").Scan(&version, &dirty)
+	if err == pgx.ErrNoRows {
+		return nilVersion, false, nil
+	}
+	return version, dirty, err
+}
+
+func setVersion(ctx context.Context, conn *pgxpool.Conn, version int, dirty bool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "TRUNCATE "+migrationsTable); err != nil {
+		return err
+	}
+	if version >= 0 || dirty {
+		if _, err := tx.Exec(ctx, "INSERT INTO "+migrationsTable+" (version, dirty) VALUES ($1, $2)", version, dirty); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// advisoryLockID derives the lock key from the database, schema and table
+// names exactly like golang-migrate.
+func advisoryLockID(database string, names ...string) int64 {
+	key := strings.Join(append(names, database), "\x00")
+	return int64(crc32.ChecksumIEEE([]byte(key)) * advisoryLockSalt)
+}
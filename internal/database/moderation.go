@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	BlockKindIP       = "ip"
+	BlockKindDeviceID = "device_id"
+)
+
+// ModerationStore keeps blocked creators and the audit log of moderation
+// actions in PostgreSQL.
+type ModerationStore struct {
+	dbConn *pgxpool.Pool
+}
+
+// BlockCreator blocks the creator and records the action in the audit log
+// in the same transaction.
+func (ms *ModerationStore) BlockCreator(ctx context.Context, kind, value, reason, actor string) error {
+	tx, err := ms.dbConn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO blocked_creators (kind, value, reason)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (kind, value) DO UPDATE SET reason = EXCLUDED.reason`,
+		kind,
+		value,
+		reason,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := audit(ctx, tx, "creator.block", actor, kind+":"+value, map[string]any{
+		"reason": reason,
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (ms *ModerationStore) IsBlocked(ctx context.Context, ip, deviceID string) (bool, error) {
+	var blocked bool
+	err := ms.dbConn.QueryRow(ctx,
+		`SELECT EXISTS (
+		     SELECT 1 FROM blocked_creators
+		     WHERE (kind = $1 AND value = $2) OR (kind = $3 AND value = $4)
+		 )`,
+		BlockKindIP,
+		ip,
+		BlockKindDeviceID,
+		deviceID,
+	).Scan(&blocked)
+	return blocked, err
+}
+
+func (ms *ModerationStore) Audit(ctx context.Context, action, actor, target string, details map[string]any) error {
+	return audit(ctx, ms.dbConn, action, actor, target, details)
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// audit writes an audit_log row, inside the caller's transaction when db is
+// one.
+func audit(ctx context.Context, db execer, action, actor, target string, details map[string]any) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO audit_log (action, actor, target, details)
+		 VALUES ($1, $2, $3, $4)`,
+		action,
+		actor,
+		target,
+		details,
+	)
+	return err
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	StrategyFixedWindow   = "fixed_window"
+	StrategySlidingWindow = "sliding_window"
+	StrategyGCRA          = "gcra"
+)
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+func NewRateLimiter(strategy string, client redis.Scripter) (Limiter, error) {
+	switch strategy {
+	case StrategyFixedWindow, "":
+		return &fixedWindowLimiter{client: client}, nil
+	case StrategySlidingWindow:
+		return &slidingWindowLimiter{client: client}, nil
+	case StrategyGCRA:
+		return &gcraLimiter{client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit strategy %q", strategy)
+	}
+}
+
+// fixedWindowScript increments the counter and sets its expiry in one step,
+// so a key can never be left behind without a TTL.
+var fixedWindowScript = redis.NewScript(`
+local current = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {current, ttl}
+`)
+
+type fixedWindowLimiter struct {
+	client redis.Scripter
+}
+
+func (l *fixedWindowLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	res, err := fixedWindowScript.Run(ctx, l.client, []string{rateLimitKey(key)}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	count, ttl := int(res[0]), time.Duration(res[1])*time.Millisecond
+	result := RateLimitResult{
+		Allowed:    count <= limit,
+		Limit:      limit,
+		Remaining:  max(limit-count, 0),
+		ResetAfter: ttl,
+	}
+	if !result.Allowed {
+		result.RetryAfter = ttl
+	}
+	return result, nil
+}
+
+// slidingWindowScript keeps a log of request timestamps in a sorted set and
+// only records the request when it fits in the window.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+type slidingWindowLimiter struct {
+	client redis.Scripter
+}
+
+func (l *slidingWindowLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	res, err := slidingWindowScript.Run(ctx, l.client, []string{rateLimitKey(key)}, window.Milliseconds(), limit, uuid.NewString()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	reset := time.Duration(res[2]) * time.Millisecond
+	result := RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      limit,
+		Remaining:  max(int(res[1]), 0),
+		ResetAfter: reset,
+	}
+	if !result.Allowed {
+		result.RetryAfter = reset
+	}
+	return result, nil
+}
+
+// gcraScript implements the generic cell rate algorithm. The key stores the
+// theoretical arrival time (TAT) in microseconds; a full burst of limit
+// requests is allowed, after which requests are spaced window/limit apart.
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+local diff = new_tat - now
+if diff > window then
+	return {0, 0, diff - window, tat - now}
+end
+
+redis.call('SET', KEYS[1], string.format('%d', new_tat), 'PX', math.ceil(diff / 1000))
+return {1, math.floor((window - diff) / interval), 0, diff}
+`)
+
+type gcraLimiter struct {
+	client redis.Scripter
+}
+
+func (l *gcraLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	interval := window.Microseconds() / int64(max(limit, 1))
+	res, err := gcraScript.Run(ctx, l.client, []string{rateLimitKey(key)}, interval, window.Microseconds()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      limit,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Microsecond,
+		ResetAfter: time.Duration(res[3]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+// reaperPause spaces out batches so that a large backlog does not hold
+// the database busy.
+const reaperPause = 100 * time.Millisecond
+
+var (
+	reaperRuns        = expvar.NewInt("reaper_runs_total")
+	reaperRemoved     = expvar.NewInt("reaper_links_removed_total")
+	reaperFailures    = expvar.NewInt("reaper_failures_total")
+	reaperLastRemoved = expvar.NewInt("reaper_last_run_removed")
+	reaperLastRun     = expvar.NewString("reaper_last_run")
+)
+
+// ReaperOptions configures the removal of expired links.
+type ReaperOptions struct {
+	// Interval between two runs. Zero disables the reaper.
+	Interval time.Duration
+	// Grace keeps links around for a while after they expire.
+	Grace time.Duration
+	// BatchSize bounds how many links are removed per statement.
+	BatchSize int
+	// Archive copies links to the archive instead of only deleting them.
+	Archive bool
+}
+
+// RunReaper removes links that expired more than the grace period ago,
+// every interval until ctx is done. Each run works in batches until no
+// expired link is left.
+func RunReaper(ctx context.Context, links LinkRepository, opts ReaperOptions) {
+	if opts.Interval <= 0 || opts.BatchSize <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := reap(ctx, links, opts)
+		reaperRuns.Add(1)
+		reaperLastRemoved.Set(int64(removed))
+		reaperLastRun.Set(time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			reaperFailures.Add(1)
+			log.Printf("reaper: %v", err)
+		}
+		if removed > 0 {
+			log.Printf("reaper: removed %d expired links", removed)
+		}
+	}
+}
+
+func reap(ctx context.Context, links LinkRepository, opts ReaperOptions) (int, error) {
+	cutoff := time.Now().Add(-opts.Grace)
+	total := 0
+	for {
+		removed, err := links.Reap(ctx, cutoff, opts.BatchSize, opts.Archive)
+		if err != nil {
+			return total, err
+		}
+		total += removed
+		reaperRemoved.Add(int64(removed))
+		if removed < opts.BatchSize {
+			return total, nil
+		}
+
+		select {
+		case <-time.After(reaperPause):
+		case <-ctx.Done():
+			return total, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	replicaReads    = expvar.NewInt("db_replica_reads_total")
+	primaryReads    = expvar.NewInt("db_primary_reads_total")
+	replicaMisses   = expvar.NewInt("db_replica_miss_fallbacks_total")
+	replicasHealthy = expvar.NewInt("db_replicas_healthy")
+)
+
+type ReplicaOptions struct {
+	Addrs []string
+	// MaxLag is how far behind the primary a replica may be and still
+	// serve reads.
+	MaxLag        time.Duration
+	CheckInterval time.Duration
+	// MissFallbacks caps how many lookups per second that miss on a
+	// replica are retried on the primary. Most misses are unknown codes,
+	// so the cap keeps scanner traffic off the primary; codes written by
+	// any instance are read from the primary anyway. Zero never retries.
+	MissFallbacks int
+}
+
+// Replicas routes read-only queries to PostgreSQL replicas that are within
+// the configured lag, falling back to the primary when none is. Codes
+// written by this instance are read from the primary until the replicas
+// have had MaxLag to catch up.
+type Replicas struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	maxLag   time.Duration
+	interval time.Duration
+	next     atomic.Uint32
+
+	mu      sync.Mutex
+	written map[string]time.Time
+
+	// Token bucket of the primary retries of replica misses, refilled at
+	// missRate per second up to one second's worth.
+	missRate   float64
+	missTokens float64
+	missAt     time.Time
+}
+
+type replica struct {
+	host    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// NewReplicas connects to every replica and checks its lag once, so reads
+// are routed correctly from the first request. With no addresses every
+// read goes to the primary. Replicas need a positive CheckInterval: Run
+// keeps their health current and forgets old writes on every check.
+func NewReplicas(ctx context.Context, primary *pgxpool.Pool, opts ReplicaOptions) (*Replicas, error) {
+	if len(opts.Addrs) > 0 && opts.CheckInterval <= 0 {
+		return nil, fmt.Errorf("replica check interval must be positive, got %s", opts.CheckInterval)
+	}
+
+	rs := &Replicas{
+		primary:  primary,
+		maxLag:   opts.MaxLag,
+		interval: opts.CheckInterval,
+		written:  map[string]time.Time{},
+
+		missRate:   float64(opts.MissFallbacks),
+		missTokens: float64(opts.MissFallbacks),
+		missAt:     time.Now(),
+	}
+
+	for _, addr := range opts.Addrs {
+		pool, err := NewDBConn(ctx, addr)
+		if err != nil {
+			rs.Close()
+			return nil, err
+		}
+		r := &replica{host: pool.Config().ConnConfig.Host, pool: pool}
+		// Start healthy so that a replica failing the first check is logged.
+		r.healthy.Store(true)
+		rs.replicas = append(rs.replicas, r)
+	}
+
+	rs.check(ctx)
+	return rs, nil
+}
+
+// Reader returns the pool to read shortCode from. Pass an empty code for
+// queries that are not about a single link.
+func (rs *Replicas) Reader(shortCode string) *pgxpool.Pool {
+	if len(rs.replicas) == 0 || (shortCode != "" && rs.recentlyWritten(shortCode)) {
+		primaryReads.Add(1)
+		return rs.primary
+	}
+
+	start := rs.next.Add(1)
+	for i := range rs.replicas {
+		r := rs.replicas[(int(start)+i)%len(rs.replicas)]
+		if r.healthy.Load() {
+			replicaReads.Add(1)
+			return r.pool
+		}
+	}
+	primaryReads.Add(1)
+	return rs.primary
+}
+
+// Wrote marks codes as just written, so that reads of them go to the
+// primary until the replicas have caught up.
+func (rs *Replicas) Wrote(codes ...string) {
+	if len(rs.replicas) == 0 {
+		return
+	}
+
+	until := time.Now().Add(rs.maxLag)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, code := range codes {
+		rs.written[code] = until
+	}
+}
+
+// staleFor is how long after a write a replica may still serve the old
+// row: the allowed lag, plus the time until a replica falling behind is
+// noticed by the next check. It is zero without replicas.
+func (rs *Replicas) staleFor() time.Duration {
+	if len(rs.replicas) == 0 {
+		return 0
+	}
+	return rs.maxLag + rs.interval
+}
+
+// allowMissFallback reports whether a lookup that missed on a replica may
+// be retried on the primary.
+func (rs *Replicas) allowMissFallback() bool {
+	if rs.missRate <= 0 {
+		return false
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	rs.missTokens = min(rs.missTokens+now.Sub(rs.missAt).Seconds()*rs.missRate, rs.missRate)
+	rs.missAt = now
+	if rs.missTokens < 1 {
+		return false
+	}
+	rs.missTokens--
+	return true
+}
+
+func (rs *Replicas) recentlyWritten(shortCode string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	until, ok := rs.written[shortCode]
+	return ok && time.Now().Before(until)
+}
+
+// Run rechecks replica lag every interval until ctx is done.
+func (rs *Replicas) Run(ctx context.Context) {
+	if len(rs.replicas) == 0 || rs.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rs.check(ctx)
+		rs.forgetWrites()
+	}
+}
+
+// check marks each replica healthy when it answers, is streaming from the
+// primary and its replay lag is within maxLag. A streaming replica that has
+// replayed everything it received counts as current even if the primary has
+// been idle for a while. A replica that lost its connection to the primary
+// has also replayed everything it received, so it is only trusted while its
+// WAL receiver is streaming.
+func (rs *Replicas) check(ctx context.Context) {
+	healthy := 0
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		var lag float64
+		var streaming bool
+		err := r.pool.QueryRow(ctx,
+			`SELECT NOT pg_is_in_recovery() OR EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming'),
+			   CASE
+			   WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			   ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+			 END`,
+		).Scan(&streaming, &lag)
+		cancel()
+
+		ok := err == nil && streaming && time.Duration(lag*float64(time.Second)) <= rs.maxLag
+		if r.healthy.Swap(ok) != ok {
+			switch {
+			case err != nil:
+				log.Printf("replica %s: %v", r.host, err)
+			case !streaming:
+				log.Printf("replica %s: not streaming from the primary, reading from the primary", r.host)
+			case !ok:
+				log.Printf("replica %s: %.1fs behind, reading from the primary", r.host, lag)
+			default:
+				log.Printf("replica %s: serving reads", r.host)
+			}
+		}
+		if ok {
+			healthy++
+		}
+	}
+	replicasHealthy.Set(int64(healthy))
+}
+
+func (rs *Replicas) forgetWrites() {
+	now := time.Now()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for code, until := range rs.written {
+		if now.After(until) {
+			delete(rs.written, code)
+		}
+	}
+}
+
+// Close closes the replica pools. The primary is owned by the caller.
+func (rs *Replicas) Close() {
+	for _, r := range rs.replicas {
+		r.pool.Close()
+	}
+}
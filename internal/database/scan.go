@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	scanBlockChannel = keyVersion + ":scan:blocks"
+	// scanBlockReload is how often the blocks are read back from Redis, in
+	// case a published block was missed while the subscription was down.
+	scanBlockReload = time.Minute
+)
+
+// ScanStore tracks clients that request unknown short codes so that
+// enumeration of the code space can be slowed down and blocked.
+//
+// Blocks are kept in Redis and published to every instance, which keeps a
+// local copy, so checking a block on each redirect costs no round-trip.
+type ScanStore struct {
+	redisClient redis.UniversalClient
+	blocks      *memoryScans
+}
+
+func (ss *ScanStore) RecordMiss(ctx context.Context, id string, window time.Duration) (int, error) {
+	res, err := fixedWindowScript.Run(ctx, ss.redisClient, []string{scanMissKey(id)}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	return int(res[0]), nil
+}
+
+func (ss *ScanStore) Block(ctx context.Context, id string, duration time.Duration) error {
+	ss.blocks.Block(ctx, id, duration)
+	if err := ss.redisClient.Set(ctx, scanBlockKey(id), 1, duration).Err(); err != nil {
+		return err
+	}
+
+	msg := strconv.FormatInt(duration.Milliseconds(), 10) + " " + id
+	if err := ss.redisClient.Publish(ctx, scanBlockChannel, msg).Err(); err != nil {
+		log.Printf("scan guard: %v", err)
+	}
+	return nil
+}
+
+// BlockedFor returns how long the client stays blocked, or zero if it is
+// not. It only reads the local copy of the blocks.
+func (ss *ScanStore) BlockedFor(ctx context.Context, id string) (time.Duration, error) {
+	return ss.blocks.BlockedFor(ctx, id)
+}
+
+// Run keeps the local copy of the blocks current until ctx is done: it
+// applies the blocks published by every instance, and reads all of them
+// back from Redis on startup and every scanBlockReload.
+func (ss *ScanStore) Run(ctx context.Context) {
+	sub := ss.redisClient.Subscribe(ctx, scanBlockChannel)
+	defer sub.Close()
+
+	// Wait for the subscription before loading, so that no block falls
+	// between the two.
+	if _, err := sub.Receive(ctx); err != nil {
+		log.Printf("scan guard: %v", err)
+	}
+	ss.reload(ctx)
+
+	ticker := time.NewTicker(scanBlockReload)
+	defer ticker.Stop()
+
+	msgs := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ss.reload(ctx)
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			ms, id, found := strings.Cut(msg.Payload, " ")
+			n, err := strconv.ParseInt(ms, 10, 64)
+			if !found || err != nil {
+				continue
+			}
+			ss.blocks.Block(ctx, id, time.Duration(n)*time.Millisecond)
+		}
+	}
+}
+
+func (ss *ScanStore) reload(ctx context.Context) {
+	ss.blocks.prune()
+
+	var err error
+	if cluster, ok := ss.redisClient.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return ss.loadBlocks(ctx, node)
+		})
+	} else {
+		err = ss.loadBlocks(ctx, ss.redisClient)
+	}
+	if err != nil {
+		log.Printf("scan guard: %v", err)
+	}
+}
+
+func (ss *ScanStore) loadBlocks(ctx context.Context, client redis.Cmdable) error {
+	prefix := scanBlockKey("")
+	iter := client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		ttl, err := client.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			ss.blocks.Block(ctx, strings.TrimPrefix(key, prefix), ttl)
+		}
+	}
+	return iter.Err()
+}
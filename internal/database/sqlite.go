@@ -0,0 +1,700 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"versiy/internal/util"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed sqlite_migrations/*.up.sql
+var sqliteMigrations embed.FS
+
+// SQLiteDB holds separate pools for reads and writes. SQLite allows a
+// single writer at a time, so every write goes through one connection and
+// queues in Go instead of failing with SQLITE_BUSY, while WAL mode lets
+// reads run alongside it.
+type SQLiteDB struct {
+	read  *sql.DB
+	write *sql.DB
+}
+
+// NewSQLiteConn opens the database file, creating it if needed, and
+// applies pending migrations.
+func NewSQLiteConn(ctx context.Context, file string) (*SQLiteDB, error) {
+	dsn := "file:" + file + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=synchronous(NORMAL)"
+
+	write, err := sql.Open("sqlite", dsn+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	write.SetMaxOpenConns(1)
+
+	read, err := sql.Open("sqlite", dsn+"&_pragma=query_only(1)")
+	if err != nil {
+		write.Close()
+		return nil, err
+	}
+
+	db := &SQLiteDB{read: read, write: write}
+	if err := migrateSQLite(ctx, write); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLiteDB) Close() error {
+	return errors.Join(db.read.Close(), db.write.Close())
+}
+
+// migrateSQLite applies the embedded migrations newer than the schema
+// version recorded in PRAGMA user_version.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	var current int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(sqliteMigrations, "sqlite_migrations/*.up.sql")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		version, err := strconv.Atoi(strings.SplitN(path.Base(file), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", file, err)
+		}
+		if version <= current {
+			continue
+		}
+
+		query, err := sqliteMigrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(query)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", file, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLiteStore is the SQLite LinkRepository and ClickRecorder. Times are
+// stored as Unix milliseconds.
+type SQLiteStore struct {
+	db    *SQLiteDB
+	codes util.CodeGenerator
+}
+
+// Store returns the newest active link to the same destination or creates
+// one. The lookup and the insert run in one write transaction, so two
+// concurrent requests for the same URL cannot both create a link.
+func (ss *SQLiteStore) Store(ctx context.Context, params URLInsert) (string, bool, error) {
+	tx, err := ss.db.write.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var shortCode string
+	err = tx.QueryRowContext(ctx,
+		`SELECT short_code FROM links
+		 WHERE original_url = ? AND expires_at > ? AND (disabled_at IS NULL OR held_at IS NOT NULL)
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		params.OriginalURL,
+		now.UnixMilli(),
+	).Scan(&shortCode)
+	if err == nil {
+		return shortCode, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", false, err
+	}
+
+	var expiresAt *int64
+	if params.ExpiresAt != nil {
+		ms := params.ExpiresAt.UnixMilli()
+		expiresAt = &ms
+	}
+
+	var held heldState
+	var heldAt *int64
+	if params.Review != nil {
+		held = newHeldState()
+		ms := held.at.UnixMilli()
+		heldAt = &ms
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO links (original_url, created_at, expires_at, device_id, disabled_at, disabled_reason, disabled_status, held_at)
+		 VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?)`,
+		params.OriginalURL,
+		now.UnixMilli(),
+		expiresAt,
+		params.DeviceID,
+		heldAt,
+		held.reason,
+		held.status,
+		heldAt,
+	)
+	if err != nil {
+		return "", false, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", false, err
+	}
+
+	shortCode, err = assignCode(ss.codes, id, func(code string) error {
+		var retired bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM retired_codes WHERE short_code = ?)", code).Scan(&retired); err != nil {
+			return err
+		}
+		if retired {
+			return errCodeTaken
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE links SET short_code = ? WHERE id = ?", code, id)
+		return err
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if params.Review != nil {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO link_reviews (link_id, domain, reason, created_at) VALUES (?, ?, ?, ?)",
+			id,
+			params.Review.Domain,
+			params.Review.Reason,
+			now.UnixMilli(),
+		)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", false, err
+	}
+	return shortCode, true, nil
+}
+
+func (ss *SQLiteStore) Get(ctx context.Context, shortCode string) (Link, error) {
+	var expiresAt int64
+	var disabledAt *int64
+	var disabledStatus *int
+
+	link := Link{Status: LinkActive, RedirectStatus: http.StatusFound}
+	err := ss.db.read.QueryRowContext(ctx,
+		`SELECT original_url, expires_at, disabled_at, disabled_status
+		 FROM links WHERE short_code = ? AND expires_at >= ?`,
+		shortCode,
+		time.Now().UnixMilli(),
+	).Scan(&link.Destination, &expiresAt, &disabledAt, &disabledStatus)
+	if err == sql.ErrNoRows {
+		return Link{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return Link{}, err
+	}
+	link.ExpiresAt = time.UnixMilli(expiresAt)
+
+	if disabledAt != nil {
+		link = Link{Status: LinkDisabled, DisabledStatus: http.StatusGone}
+		if disabledStatus != nil {
+			link.DisabledStatus = *disabledStatus
+		}
+	}
+	return link, nil
+}
+
+func (ss *SQLiteStore) LastTimeAccessed(ctx context.Context, shortCode string) error {
+	_, err := ss.db.write.ExecContext(ctx,
+		"UPDATE links SET last_time_accessed = ? WHERE short_code = ?",
+		time.Now().UnixMilli(),
+		shortCode,
+	)
+	return err
+}
+
+func (ss *SQLiteStore) UpdateClicks(ctx context.Context, shortCode string) error {
+	res, err := ss.db.write.ExecContext(ctx,
+		`INSERT INTO links_clicks (link_id, clicks)
+		 SELECT id, 1 FROM links WHERE short_code = ?
+		 ON CONFLICT (link_id) DO UPDATE SET clicks = clicks + 1`,
+		shortCode,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+func (ss *SQLiteStore) Disable(ctx context.Context, shortCode, reason string, status int, actor string) error {
+	tx, err := ss.db.write.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE links
+		 SET disabled_at = ?, disabled_reason = ?, disabled_status = ?, held_at = NULL
+		 WHERE short_code = ?`,
+		time.Now().UnixMilli(),
+		reason,
+		status,
+		shortCode,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLinkNotFound
+	}
+
+	if err := sqliteAudit(ctx, tx, "link.disable", actor, shortCode, map[string]any{
+		"reason": reason,
+		"status": status,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableDomain matches hosts in Go since SQLite has no regular
+// expressions. It scans every active link, which is fine for the
+// deployments this backend is meant for.
+func (ss *SQLiteStore) DisableDomain(ctx context.Context, domain, reason string, status int, actor string) ([]string, error) {
+	tx, err := ss.db.write.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT short_code, original_url FROM links
+		 WHERE (disabled_at IS NULL OR held_at IS NOT NULL) AND short_code IS NOT NULL`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	for rows.Next() {
+		var code, originalURL string
+		if err := rows.Scan(&code, &originalURL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if matchesDomain(originalURL, domain) {
+			codes = append(codes, code)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	for _, code := range codes {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE links
+			 SET disabled_at = ?, disabled_reason = ?, disabled_status = ?, held_at = NULL
+			 WHERE short_code = ?`,
+			now,
+			reason,
+			status,
+			code,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sqliteAudit(ctx, tx, "domain.disable", actor, domain, map[string]any{
+		"reason":   reason,
+		"status":   status,
+		"disabled": len(codes),
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (ss *SQLiteStore) Search(ctx context.Context, destination string, limit int) ([]LinkSummary, error) {
+	rows, err := ss.db.read.QueryContext(ctx,
+		`SELECT short_code, original_url, created_at, expires_at, disabled_at, disabled_reason
+		 FROM links
+		 WHERE short_code IS NOT NULL AND instr(lower(original_url), lower(?)) > 0
+		 ORDER BY created_at DESC
+		 LIMIT ?`,
+		destination,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []LinkSummary{}
+	for rows.Next() {
+		var link LinkSummary
+		var createdAt, expiresAt int64
+		var disabledAt *int64
+		if err := rows.Scan(&link.ShortCode, &link.OriginalURL, &createdAt, &expiresAt, &disabledAt, &link.DisabledReason); err != nil {
+			return nil, err
+		}
+		link.CreatedAt, link.ExpiresAt = time.UnixMilli(createdAt), time.UnixMilli(expiresAt)
+		if disabledAt != nil {
+			t := time.UnixMilli(*disabledAt)
+			link.DisabledAt = &t
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// Reap removes up to limit links that expired before cutoff, with their
+// clicks, copying them to links_archive first when archive is set.
+func (ss *SQLiteStore) Reap(ctx context.Context, cutoff time.Time, limit int, archive bool) (int, error) {
+	tx, err := ss.db.write.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The write transaction holds the database lock, so the batch cannot
+	// change between the copy and the delete.
+	const expired = "SELECT id FROM links WHERE expires_at < ? ORDER BY expires_at LIMIT ?"
+	if archive {
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO links_archive (id, original_url, short_code, created_at, expires_at,
+			     last_time_accessed, device_id, disabled_at, disabled_reason, disabled_status, clicks, archived_at)
+			 SELECT l.id, l.original_url, l.short_code, l.created_at, l.expires_at,
+			     l.last_time_accessed, l.device_id, l.disabled_at, l.disabled_reason, l.disabled_status,
+			     COALESCE(c.clicks, 0), ?
+			 FROM links l
+			 LEFT JOIN links_clicks c ON c.link_id = l.id
+			 WHERE l.id IN (`+expired+`)`,
+			time.Now().UnixMilli(),
+			cutoff.UnixMilli(),
+			limit,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Reaped codes are never issued again, so old links cannot start
+	// redirecting somewhere else.
+	_, err = tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO retired_codes (short_code, retired_at)
+		 SELECT short_code, ? FROM links WHERE short_code IS NOT NULL AND id IN (`+expired+`)`,
+		time.Now().UnixMilli(),
+		cutoff.UnixMilli(),
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM links WHERE id IN ("+expired+")", cutoff.UnixMilli(), limit)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
+
+// sqliteLimiter is a fixed window limiter kept in the rate_limits table,
+// so limits survive a restart. Expired windows are swept once a minute.
+type sqliteLimiter struct {
+	db *SQLiteDB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (l *sqliteLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	now := time.Now()
+	if err := l.sweep(ctx, now); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	var count int
+	var resetAt int64
+	err := l.db.write.QueryRowContext(ctx,
+		`INSERT INTO rate_limits (key, count, reset_at) VALUES (?1, 1, ?2)
+		 ON CONFLICT (key) DO UPDATE SET
+		     count = CASE WHEN reset_at <= ?3 THEN 1 ELSE count + 1 END,
+		     reset_at = CASE WHEN reset_at <= ?3 THEN ?2 ELSE reset_at END
+		 RETURNING count, reset_at`,
+		key,
+		now.Add(window).UnixMilli(),
+		now.UnixMilli(),
+	).Scan(&count, &resetAt)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	reset := time.UnixMilli(resetAt).Sub(now)
+	result := RateLimitResult{
+		Allowed:    count <= limit,
+		Limit:      limit,
+		Remaining:  max(limit-count, 0),
+		ResetAfter: reset,
+	}
+	if !result.Allowed {
+		result.RetryAfter = reset
+	}
+	return result, nil
+}
+
+func (l *sqliteLimiter) sweep(ctx context.Context, now time.Time) error {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) < time.Minute {
+		l.mu.Unlock()
+		return nil
+	}
+	l.lastSweep = now
+	l.mu.Unlock()
+
+	_, err := l.db.write.ExecContext(ctx, "DELETE FROM rate_limits WHERE reset_at <= ?", now.UnixMilli())
+	return err
+}
+
+// sqliteModeration keeps blocked creators and the audit log in SQLite.
+type sqliteModeration struct {
+	db *SQLiteDB
+}
+
+func (sm *sqliteModeration) BlockCreator(ctx context.Context, kind, value, reason, actor string) error {
+	tx, err := sm.db.write.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO blocked_creators (kind, value, reason, created_at)
+		 VALUES (?, ?, ?, ?)
+		 ON CONFLICT (kind, value) DO UPDATE SET reason = excluded.reason`,
+		kind,
+		value,
+		reason,
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := sqliteAudit(ctx, tx, "creator.block", actor, kind+":"+value, map[string]any{
+		"reason": reason,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (sm *sqliteModeration) IsBlocked(ctx context.Context, ip, deviceID string) (bool, error) {
+	var blocked bool
+	err := sm.db.read.QueryRowContext(ctx,
+		`SELECT EXISTS (
+		     SELECT 1 FROM blocked_creators
+		     WHERE (kind = ? AND value = ?) OR (kind = ? AND value = ?)
+		 )`,
+		BlockKindIP,
+		ip,
+		BlockKindDeviceID,
+		deviceID,
+	).Scan(&blocked)
+	return blocked, err
+}
+
+func (sm *sqliteModeration) Audit(ctx context.Context, action, actor, target string, details map[string]any) error {
+	return sqliteAudit(ctx, sm.db.write, action, actor, target, details)
+}
+
+type sqliteExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sqliteAudit writes an audit_log row with the details as JSON, inside the
+// caller's transaction when db is one.
+func sqliteAudit(ctx context.Context, db sqliteExecer, action, actor, target string, details map[string]any) error {
+	var encoded *string
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		s := string(b)
+		encoded = &s
+	}
+
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO audit_log (action, actor, target, details, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		action,
+		actor,
+		target,
+		encoded,
+		time.Now().UnixMilli(),
+	)
+	return err
+}
+
+// sqliteDomains resolves the reviews of links held by SQLiteStore.Store.
+// Creations per domain are counted in process, in fixed windows.
+type sqliteDomains struct {
+	db        *SQLiteDB
+	creations *localLimiter
+}
+
+func (sd *sqliteDomains) ReserveCreation(ctx context.Context, domain string, window time.Duration) (int, error) {
+	count, _ := sd.creations.add(domain, window, 1)
+	return count, nil
+}
+
+func (sd *sqliteDomains) ReleaseCreation(ctx context.Context, domain string, window time.Duration) error {
+	sd.creations.add(domain, window, -1)
+	return nil
+}
+
+func (sd *sqliteDomains) PendingReviews(ctx context.Context, limit int) ([]LinkReview, error) {
+	rows, err := sd.db.read.QueryContext(ctx,
+		`SELECT r.id, l.short_code, l.original_url, r.domain, r.reason, r.created_at
+		 FROM link_reviews r
+		 JOIN links l ON l.id = r.link_id
+		 WHERE r.resolved_at IS NULL
+		 ORDER BY r.created_at
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []LinkReview{}
+	for rows.Next() {
+		var r LinkReview
+		var createdAt int64
+		if err := rows.Scan(&r.ID, &r.ShortCode, &r.OriginalURL, &r.Domain, &r.Reason, &createdAt); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = time.UnixMilli(createdAt)
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+func (sd *sqliteDomains) ResolveReview(ctx context.Context, id int, approve bool, actor string) (string, error) {
+	tx, err := sd.db.write.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var linkID int64
+	var shortCode string
+	err = tx.QueryRowContext(ctx,
+		`SELECT l.id, l.short_code FROM link_reviews r
+		 JOIN links l ON l.id = r.link_id
+		 WHERE r.id = ? AND r.resolved_at IS NULL`,
+		id,
+	).Scan(&linkID, &shortCode)
+	if err == sql.ErrNoRows {
+		return "", ErrReviewNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UnixMilli()
+	if _, err := tx.ExecContext(ctx, "UPDATE link_reviews SET resolved_at = ? WHERE id = ?", now, id); err != nil {
+		return "", err
+	}
+
+	action := "review.approve"
+	if approve {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE links SET disabled_at = NULL, disabled_reason = NULL, disabled_status = NULL, held_at = NULL
+			 WHERE id = ? AND held_at IS NOT NULL`,
+			linkID,
+		)
+	} else {
+		action = "review.reject"
+		_, err = tx.ExecContext(ctx,
+			`UPDATE links SET disabled_at = ?, disabled_reason = ?, disabled_status = ?, held_at = NULL
+			 WHERE id = ? AND held_at IS NOT NULL`,
+			now,
+			reviewRejectedReason,
+			http.StatusGone,
+			linkID,
+		)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := sqliteAudit(ctx, tx, action, actor, shortCode, map[string]any{
+		"review": id,
+	}); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return shortCode, nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS links_clicks;
+DROP TABLE IF EXISTS links;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS links(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    original_url TEXT NOT NULL,
+    short_code TEXT UNIQUE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    last_time_accessed INTEGER,
+    device_id TEXT,
+    disabled_at INTEGER,
+    disabled_reason TEXT,
+    disabled_status INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS links_original_url_idx ON links(original_url);
+CREATE INDEX IF NOT EXISTS links_device_id_idx ON links(device_id);
+
+CREATE TABLE IF NOT EXISTS links_clicks(
+    link_id INTEGER PRIMARY KEY REFERENCES links(id) ON DELETE CASCADE,
+    clicks INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS links_archive(
+    id INTEGER PRIMARY KEY,
+    original_url TEXT NOT NULL,
+    short_code TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    last_time_accessed INTEGER,
+    device_id TEXT,
+    disabled_at INTEGER,
+    disabled_reason TEXT,
+    disabled_status INTEGER,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    archived_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS links_expires_at_idx ON links(expires_at);
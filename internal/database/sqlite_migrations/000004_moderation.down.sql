@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS link_reviews_pending_idx;
+DROP TABLE IF EXISTS link_reviews;
+ALTER TABLE links DROP COLUMN held_at;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS blocked_creators;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS blocked_creators(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    reason TEXT,
+    created_at INTEGER NOT NULL,
+    UNIQUE (kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    target TEXT NOT NULL,
+    details TEXT,
+    created_at INTEGER NOT NULL
+);
+
+ALTER TABLE links ADD COLUMN held_at INTEGER;
+
+CREATE TABLE IF NOT EXISTS link_reviews(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    link_id INTEGER UNIQUE NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    domain TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    resolved_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS link_reviews_pending_idx ON link_reviews(created_at) WHERE resolved_at IS NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS retired_codes(
+    short_code TEXT PRIMARY KEY,
+    retired_at INTEGER NOT NULL
+);
+
+INSERT OR IGNORE INTO retired_codes (short_code, retired_at)
+SELECT short_code, archived_at FROM links_archive WHERE short_code IS NOT NULL;
@@ -3,28 +3,195 @@ package database
 import (
 	"context"
 	"time"
+	"versiy/internal/util"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// BackendPostgres keeps links in PostgreSQL and shared state in Redis.
+	BackendPostgres = "postgres"
+	// BackendMemory keeps everything in process. Nothing survives a restart
+	// and limits only hold per instance.
+	BackendMemory = "memory"
+	// BackendSQLite keeps links and moderation state in a SQLite file and
+	// caches and counters in process, for single-instance deployments.
+	BackendSQLite = "sqlite"
+)
+
+// LinkRepository persists links and their moderation state. Unknown and
+// expired codes are reported as ErrLinkNotFound.
+type LinkRepository interface {
+	// Store returns the code of an active link to the same destination, or
+	// creates one and reports created.
+	Store(ctx context.Context, params URLInsert) (shortCode string, created bool, err error)
+	Get(ctx context.Context, shortCode string) (Link, error)
+	LastTimeAccessed(ctx context.Context, shortCode string) error
+	// Disable disables the link and writes the audit entry for actor in the
+	// same transaction.
+	Disable(ctx context.Context, shortCode, reason string, status int, actor string) error
+	// DisableDomain disables every active link to domain or one of its
+	// subdomains and returns their codes. The audit entry is written in the
+	// same transaction.
+	DisableDomain(ctx context.Context, domain, reason string, status int, actor string) ([]string, error)
+	Search(ctx context.Context, destination string, limit int) ([]LinkSummary, error)
+	// Reap removes up to limit links that expired before cutoff, together
+	// with their clicks, and returns how many were removed. With archive
+	// set they are copied to the archive first.
+	Reap(ctx context.Context, cutoff time.Time, limit int, archive bool) (int, error)
+}
+
+// ClickRecorder counts redirects per link.
+type ClickRecorder interface {
+	UpdateClicks(ctx context.Context, shortCode string) error
+}
+
+// Cache holds resolved links in front of the LinkRepository. A miss is
+// reported as ErrCacheMiss.
+type Cache interface {
+	CheckCached(ctx context.Context, shortCode string) (Link, error)
+	CacheResult(ctx context.Context, shortCode string, link Link, TTL time.Duration) error
+	Invalidate(ctx context.Context, shortCodes ...string) error
+	// AcquireFillLock reports whether the caller may fill the cache for the
+	// code, false when another instance is already doing so.
+	AcquireFillLock(ctx context.Context, shortCode string, TTL time.Duration) (bool, error)
+}
+
+// Limiter decides whether the client identified by key may perform
+// another request, allowing at most limit requests per window.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
 type Storage struct {
-	URL interface {
-		Store(ctx context.Context, params URLInsert, secret string) (string, error)
-		Get(ctx context.Context, shortCode string) (string, error)
-		LastTimeAccessed(ctx context.Context, shortCode string) error
-		UpdateClicks(ctx context.Context, shortCode string) error
-		CacheResult(ctx context.Context, shortCode, url string, TTL time.Duration) error
-		CheckCached(ctx context.Context, shortCode string) (string, error)
+	Links    LinkRepository
+	Clicks   ClickRecorder
+	Cache    Cache
+	Limiter  Limiter
+	Resolver interface {
+		Load(ctx context.Context, shortCode string, TTL time.Duration) (Link, error)
+	}
+	Hot interface {
+		Track(shortCode string)
+		Top(ctx context.Context, n int) ([]HotLink, error)
+		Run(ctx context.Context)
+		WarmUp(ctx context.Context, TTL time.Duration)
+	}
+	Scans interface {
+		// Run keeps any local state current until ctx is done.
+		Run(ctx context.Context)
+		RecordMiss(ctx context.Context, id string, window time.Duration) (int, error)
+		Block(ctx context.Context, id string, duration time.Duration) error
+		BlockedFor(ctx context.Context, id string) (time.Duration, error)
 	}
-	Users interface {
-		IncrUser(ctx context.Context, id string, duration time.Duration) (int, error)
+	Domains interface {
+		// ReserveCreation counts a link about to be created for domain and
+		// returns the count within the window, including it.
+		ReserveCreation(ctx context.Context, domain string, window time.Duration) (int, error)
+		// ReleaseCreation gives back a reservation for a link that was not
+		// created after all.
+		ReleaseCreation(ctx context.Context, domain string, window time.Duration) error
+		PendingReviews(ctx context.Context, limit int) ([]LinkReview, error)
+		// ResolveReview enables the held link when approve is set and
+		// disables it otherwise, writing the audit entry for actor in the
+		// same transaction. It returns the code of the link.
+		ResolveReview(ctx context.Context, id int, approve bool, actor string) (string, error)
 	}
+	Moderation interface {
+		// BlockCreator writes the audit entry for actor in the same
+		// transaction as the block.
+		BlockCreator(ctx context.Context, kind, value, reason, actor string) error
+		IsBlocked(ctx context.Context, ip, deviceID string) (bool, error)
+		Audit(ctx context.Context, action, actor, target string, details map[string]any) error
+	}
+}
+
+// CacheOptions configures the redirect cache in front of the repository.
+type CacheOptions struct {
+	// Local is the in-process tier in front of Redis, nil to disable it.
+	// The memory backend uses it as its only cache.
+	Local *LocalCache
+	// FillLockTTL enables a Redis lock so that only one instance queries
+	// the database for a code missing from the cache. Zero disables it.
+	FillLockTTL time.Duration
+	// NotFoundTTL is how long unknown and expired codes are cached as
+	// LinkNotFound. Zero disables negative caching.
+	NotFoundTTL time.Duration
+	// HotLinks is how many of the most requested codes are tracked and
+	// preloaded on startup. Zero disables tracking.
+	HotLinks int
+	// HotInterval is how often tracked hits are flushed to Redis.
+	HotInterval time.Duration
+}
+
+// NewStorage returns the PostgreSQL and Redis backend. Link lookups are
+// read through reads.
+func NewStorage(conn *pgxpool.Pool, reads *Replicas, redis redis.UniversalClient, limiter Limiter, codes util.CodeGenerator, cache CacheOptions) Storage {
+	urls := &URLStore{dbConn: conn, reads: reads, codes: codes}
+	redisCache := &RedisCache{redisClient: redis, local: cache.Local, reads: reads}
+	resolver := NewResolver(urls, redisCache, cache.FillLockTTL, cache.NotFoundTTL)
+	return Storage{
+		Links:    urls,
+		Clicks:   urls,
+		Cache:    redisCache,
+		Limiter:  limiter,
+		Resolver: resolver,
+		Hot: &HotStore{
+			dbConn:      conn,
+			redisClient: redis,
+			resolver:    resolver,
+			cache:       redisCache,
+			size:        cache.HotLinks,
+			interval:    cache.HotInterval,
+			pending:     map[string]int{},
+		},
+		Scans: &ScanStore{
+			redisClient: redis,
+			blocks:      &memoryScans{blocked: map[string]time.Time{}},
+		},
+		Domains:    &DomainStore{dbConn: conn, redisClient: redis},
+		Moderation: &ModerationStore{dbConn: conn},
+	}
+}
+
+// NewMemoryStorage returns a backend that keeps everything in process, for
+// development and tests that should not need PostgreSQL or Redis.
+func NewMemoryStorage(codes util.CodeGenerator, cache CacheOptions) Storage {
+	links := NewMemoryLinks(codes)
+	store := localStorage(links, links, newLocalLimiter(), cache)
+	store.Domains = &memoryDomains{creations: newLocalLimiter(), links: links}
+	store.Moderation = &memoryModeration{blocked: map[string]string{}}
+	return store
+}
+
+// NewSQLiteStorage returns a backend that keeps links, clicks, rate limits,
+// blocked creators, the review queue and the audit log in SQLite. The
+// cache and the scan and hot link stores are in process.
+func NewSQLiteStorage(db *SQLiteDB, codes util.CodeGenerator, cache CacheOptions) Storage {
+	links := &SQLiteStore{db: db, codes: codes}
+	store := localStorage(links, links, &sqliteLimiter{db: db}, cache)
+	store.Domains = &sqliteDomains{db: db, creations: newLocalLimiter()}
+	store.Moderation = &sqliteModeration{db: db}
+	return store
 }
 
-func NewStorage(conn *pgxpool.Pool, redis *redis.Client) Storage {
+// localStorage completes a single-instance backend with the in-process
+// cache, hot link and scan stores.
+func localStorage(links LinkRepository, clicks ClickRecorder, limiter Limiter, cache CacheOptions) Storage {
+	memCache := &memoryCache{local: cache.Local}
+	resolver := NewResolver(links, memCache, 0, cache.NotFoundTTL)
 	return Storage{
-		URL:   &URLStore{dbConn: conn, redisClient: redis},
-		Users: &UsersStore{redisClient: redis},
+		Links:    links,
+		Clicks:   clicks,
+		Cache:    memCache,
+		Limiter:  limiter,
+		Resolver: resolver,
+		Hot: &memoryHot{
+			size:     cache.HotLinks,
+			interval: cache.HotInterval,
+			scores:   map[string]float64{},
+		},
+		Scans: &memoryScans{misses: newLocalLimiter(), blocked: map[string]time.Time{}},
 	}
 }
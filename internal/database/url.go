@@ -2,110 +2,217 @@ package database
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"time"
 	"versiy/internal/util"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 )
 
+var ErrLinkNotFound = errors.New("link not found")
+
+// dedupLockSpace is the first key of the advisory locks taken per
+// destination in Store. Two-key locks never collide with the single-key
+// lock held by the Migrator.
+const dedupLockSpace int32 = 0x6c696e6b
+
+// URLStore is the PostgreSQL LinkRepository and ClickRecorder. Writes go
+// to dbConn and lookups to reads.
 type URLStore struct {
-	dbConn      *pgxpool.Pool
-	redisClient *redis.Client
+	dbConn *pgxpool.Pool
+	reads  *Replicas
+	codes  util.CodeGenerator
+}
+
+const (
+	LinkActive   = "active"
+	LinkDisabled = "disabled"
+	LinkNotFound = "not_found"
+)
+
+// Link holds everything needed to answer a redirect without PostgreSQL. It
+// is stored as JSON under linkKey, so fields can be added without
+// invalidating entries already cached.
+type Link struct {
+	Status         string    `json:"status"`
+	Destination    string    `json:"destination,omitempty"`
+	RedirectStatus int       `json:"redirect_status,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+	// DisabledStatus is the only part of a disable that is public. The
+	// reason may hold moderation notes, so it stays in the admin and audit
+	// views and is never cached.
+	DisabledStatus int `json:"disabled_status,omitempty"`
+}
+
+// Expired reports whether an active link has passed its expiry.
+func (l Link) Expired() bool {
+	return l.Status == LinkActive && !l.ExpiresAt.IsZero() && time.Now().After(l.ExpiresAt)
 }
 
 type URLInsert struct {
 	OriginalURL string
 	ExpiresAt   *time.Time
+	DeviceID    string
+	// Review holds a new link: it is created disabled and queued until an
+	// admin approves or rejects it. Nil creates the link live.
+	Review *ReviewHold
 }
 
-func (us *URLStore) Store(ctx context.Context, params URLInsert, secret string) (string, error) {
-	var existingShortCode string
-	var existingID int64
+type LinkSummary struct {
+	ShortCode      string     `json:"short_code"`
+	OriginalURL    string     `json:"original_url"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason *string    `json:"disabled_reason,omitempty"`
+}
 
-	err := us.dbConn.QueryRow(ctx,
-		`SELECT short_code, id FROM links
-		 WHERE original_url = $1 AND expires_at > $2
+// Store returns the code of an active or held link to the same
+// destination, or creates one. Concurrent calls for the same destination are serialized by
+// a transaction-scoped advisory lock, and the row is inserted with its code
+// already set, so no other session ever sees a link without one.
+func (us *URLStore) Store(ctx context.Context, params URLInsert) (string, bool, error) {
+	tx, err := us.dbConn.Begin(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", dedupLockSpace, params.OriginalURL); err != nil {
+		return "", false, err
+	}
+
+	var shortCode string
+	err = tx.QueryRow(ctx,
+		`SELECT short_code FROM links
+		 WHERE original_url = $1 AND expires_at > $2 AND (disabled_at IS NULL OR held_at IS NOT NULL)
 		 ORDER BY created_at DESC
 		 LIMIT 1`,
 		params.OriginalURL,
 		time.Now(),
-	).Scan(&existingShortCode, &existingID)
-
+	).Scan(&shortCode)
 	if err == nil {
-		return existingShortCode, nil
+		return shortCode, false, nil
 	}
-
 	if err != pgx.ErrNoRows {
-		return "", err
+		return "", false, err
 	}
 
-	tx, err := us.dbConn.Begin(ctx)
-	if err != nil {
-		return "", err
+	// The id is taken from the sequence up front because the hashed and
+	// sequence strategies derive the code from it.
+	var id int64
+	if err := tx.QueryRow(ctx, "SELECT nextval(pg_get_serial_sequence('links', 'id'))").Scan(&id); err != nil {
+		return "", false, err
 	}
-	defer tx.Rollback(ctx)
 
-	var id int64
-	err = tx.QueryRow(ctx,
-		`INSERT INTO links (original_url, expires_at)
-		 VALUES ($1, $2)
-		 RETURNING id`,
-		params.OriginalURL,
-		params.ExpiresAt,
-	).Scan(&id)
-	if err != nil {
-		return "", err
+	var held heldState
+	if params.Review != nil {
+		held = newHeldState()
 	}
 
-	shortCode := util.GenerateShortCode(secret, id)
+	shortCode, err = assignCode(us.codes, id, func(code string) error {
+		// A failed statement aborts the transaction, so each attempt runs
+		// in its own savepoint.
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = sp.Exec(ctx,
+			`INSERT INTO links (id, original_url, short_code, expires_at, device_id, disabled_at, disabled_reason, disabled_status, held_at)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $7, $8, $6)`,
+			id,
+			params.OriginalURL,
+			code,
+			params.ExpiresAt,
+			params.DeviceID,
+			held.at,
+			held.reason,
+			held.status,
+		)
+		if err != nil {
+			sp.Rollback(ctx)
+			return err
+		}
 
-	_, err = tx.Exec(ctx,
-		`UPDATE links
-		 SET short_code = $1
-		 WHERE id = $2`,
-		shortCode,
-		id,
-	)
+		// Checked after the insert: a reaper removing a link with this code
+		// makes the insert wait for its commit, and the tombstone is then
+		// visible to this statement.
+		var retired bool
+		if err := sp.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM retired_codes WHERE short_code = $1)", code).Scan(&retired); err != nil {
+			sp.Rollback(ctx)
+			return err
+		}
+		if retired {
+			sp.Rollback(ctx)
+			return errCodeTaken
+		}
+		return sp.Commit(ctx)
+	})
 	if err != nil {
-		return "", err
+		return "", false, err
+	}
+
+	if params.Review != nil {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO link_reviews (link_id, domain, reason) VALUES ($1, $2, $3)",
+			id,
+			params.Review.Domain,
+			params.Review.Reason,
+		)
+		if err != nil {
+			return "", false, err
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return "", err
+		return "", false, err
 	}
 
-	return shortCode, nil
+	us.reads.Wrote(shortCode)
+	return shortCode, true, nil
 }
 
-func (us *URLStore) Get(ctx context.Context, shortCode string) (string, error) {
-	var url string
-	err := us.dbConn.QueryRow(ctx,
-		"SELECT original_url FROM links WHERE short_code = $1 AND expires_at >= $2",
-		shortCode,
-		time.Now(),
-	).Scan(&url)
-	if err != nil {
-		return "", err
+// Get loads the link from PostgreSQL. Expired and unknown codes return
+// ErrLinkNotFound. A code missing on a replica may have been created by
+// another instance moments ago, before its invalidation message arrived, so
+// it is looked up again on the primary within the MissFallbacks budget.
+func (us *URLStore) Get(ctx context.Context, shortCode string) (Link, error) {
+	pool := us.reads.Reader(shortCode)
+	link, err := us.get(ctx, pool, shortCode)
+	if err == ErrLinkNotFound && pool != us.dbConn && us.reads.allowMissFallback() {
+		replicaMisses.Add(1)
+		return us.get(ctx, us.dbConn, shortCode)
 	}
-	return url, nil
+	return link, err
 }
 
-func (us *URLStore) CacheResult(ctx context.Context, shortCode, url string, TTL time.Duration) error {
-	status := us.redisClient.Set(ctx, shortCode, url, TTL)
-	if status.Err() != nil {
-		return status.Err()
-	}
-	return nil
-}
+func (us *URLStore) get(ctx context.Context, pool *pgxpool.Pool, shortCode string) (Link, error) {
+	var disabledAt *time.Time
+	var disabledStatus *int
 
-func (us *URLStore) CheckCached(ctx context.Context, shortCode string) (string, error) {
-	value, err := us.redisClient.Get(ctx, shortCode).Result()
+	link := Link{Status: LinkActive, RedirectStatus: http.StatusFound}
+	err := pool.QueryRow(ctx,
+		`SELECT original_url, expires_at, disabled_at, disabled_status
+		 FROM links WHERE short_code = $1 AND expires_at >= $2`,
+		shortCode,
+		time.Now(),
+	).Scan(&link.Destination, &link.ExpiresAt, &disabledAt, &disabledStatus)
+	if err == pgx.ErrNoRows {
+		return Link{}, ErrLinkNotFound
+	}
 	if err != nil {
-		return "", err
+		return Link{}, err
 	}
-	return value, nil
+
+	if disabledAt != nil {
+		link = Link{Status: LinkDisabled, DisabledStatus: http.StatusGone}
+		if disabledStatus != nil {
+			link.DisabledStatus = *disabledStatus
+		}
+	}
+	return link, nil
 }
 
 func (us *URLStore) LastTimeAccessed(ctx context.Context, shortCode string) error {
@@ -137,3 +244,156 @@ func (us *URLStore) UpdateClicks(ctx context.Context, shortCode string) error {
 	}
 	return nil
 }
+
+// Disable disables the link and records the action in the audit log in
+// the same transaction.
+func (us *URLStore) Disable(ctx context.Context, shortCode, reason string, status int, actor string) error {
+	tx, err := us.dbConn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE links
+		 SET disabled_at = $1, disabled_reason = $2, disabled_status = $3, held_at = NULL
+		 WHERE short_code = $4`,
+		time.Now(),
+		reason,
+		status,
+		shortCode,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLinkNotFound
+	}
+
+	if err := audit(ctx, tx, "link.disable", actor, shortCode, map[string]any{
+		"reason": reason,
+		"status": status,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	us.reads.Wrote(shortCode)
+	return nil
+}
+
+// DisableDomain disables the links, including those held for review, and
+// records the action in the audit log in the same transaction.
+func (us *URLStore) DisableDomain(ctx context.Context, domain, reason string, status int, actor string) ([]string, error) {
+	tx, err := us.dbConn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`UPDATE links
+		 SET disabled_at = $1, disabled_reason = $2, disabled_status = $3, held_at = NULL
+		 FROM (
+		     SELECT id, lower(substring(original_url from '^https?://(?:[^@/]*@)?([^/:?#]+)')) AS host
+		     FROM links
+		     WHERE disabled_at IS NULL OR held_at IS NOT NULL
+		 ) matched
+		 WHERE links.id = matched.id
+		   AND (matched.host = $4 OR right(matched.host, length($4) + 1) = '.' || $4)
+		 RETURNING links.short_code`,
+		time.Now(),
+		reason,
+		status,
+		domain,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	disabled, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := audit(ctx, tx, "domain.disable", actor, domain, map[string]any{
+		"reason":   reason,
+		"status":   status,
+		"disabled": len(disabled),
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	us.reads.Wrote(disabled...)
+	return disabled, nil
+}
+
+func (us *URLStore) Search(ctx context.Context, destination string, limit int) ([]LinkSummary, error) {
+	rows, err := us.reads.Reader("").Query(ctx,
+		`SELECT short_code, original_url, created_at, expires_at, disabled_at, disabled_reason
+		 FROM links
+		 WHERE short_code IS NOT NULL AND strpos(lower(original_url), lower($1)) > 0
+		 ORDER BY created_at DESC
+		 LIMIT $2`,
+		destination,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[LinkSummary])
+}
+
+// Reap removes up to limit links that expired before cutoff, with their
+// clicks. With archive set they are copied to links_archive first. Their
+// codes are kept in retired_codes so that they are never issued again. Rows
+// are claimed with SKIP LOCKED, so reapers on several instances work on
+// separate batches.
+func (us *URLStore) Reap(ctx context.Context, cutoff time.Time, limit int, archive bool) (int, error) {
+	// Every part of the statement sees the same snapshot, so the click
+	// counts are read before the cascade removes them. The deleted rows are
+	// counted rather than the archived ones, since a link already in the
+	// archive is skipped.
+	archived := ""
+	if archive {
+		archived = `, archived AS (
+		     INSERT INTO links_archive (id, original_url, short_code, created_at, expires_at, last_time_accessed,
+		         device_id, disabled_at, disabled_reason, disabled_status, clicks)
+		     SELECT r.id, r.original_url, r.short_code, r.created_at, r.expires_at, r.last_time_accessed,
+		         r.device_id, r.disabled_at, r.disabled_reason, r.disabled_status, COALESCE(c.clicks, 0)
+		     FROM removed r
+		     LEFT JOIN links_clicks c ON c.link_id = r.id
+		     ON CONFLICT (id) DO NOTHING
+		 )`
+	}
+
+	var removed int
+	err := us.dbConn.QueryRow(ctx,
+		`WITH expired AS (
+		     SELECT id FROM links
+		     WHERE expires_at < $1
+		     ORDER BY expires_at
+		     LIMIT $2
+		     FOR UPDATE SKIP LOCKED
+		 ), removed AS (
+		     DELETE FROM links USING expired WHERE links.id = expired.id
+		     RETURNING links.*
+		 ), retired AS (
+		     INSERT INTO retired_codes (short_code)
+		     SELECT short_code FROM removed
+		     ON CONFLICT (short_code) DO NOTHING
+		 )`+archived+`
+		 SELECT count(*) FROM removed`,
+		cutoff,
+		limit,
+	).Scan(&removed)
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
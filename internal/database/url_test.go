@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"versiy/cmd/migrate/migrations"
+	"versiy/internal/util"
+)
+
+// newTestURLStore connects to POSTGRES_ADDR and applies the migrations. The
+// test is skipped when no database is configured.
+func newTestURLStore(t *testing.T) *URLStore {
+	t.Helper()
+
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		t.Skip("POSTGRES_ADDR is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := NewDBConn(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+
+	migrator, err := NewMigrator(conn, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reads, err := NewReplicas(ctx, conn, ReplicaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(reads.Close)
+
+	codes, err := util.NewCodeGenerator(util.CodeOptions{Secret: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &URLStore{dbConn: conn, reads: reads, codes: codes}
+}
+
+func TestStoreConcurrentSameURL(t *testing.T) {
+	us := newTestURLStore(t)
+	ctx := context.Background()
+
+	originalURL := fmt.Sprintf("https://example.com/concurrent/%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		us.dbConn.Exec(ctx, "DELETE FROM links WHERE original_url = $1", originalURL)
+	})
+
+	const workers = 20
+	expiresAt := time.Now().Add(time.Hour)
+
+	var wg sync.WaitGroup
+	codes := make([]string, workers)
+	created := make([]bool, workers)
+	errs := make([]error, workers)
+	start := make(chan struct{})
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			codes[i], created[i], errs[i] = us.Store(ctx, URLInsert{
+				OriginalURL: originalURL,
+				ExpiresAt:   &expiresAt,
+			})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	createdCount := 0
+	for i := range workers {
+		if errs[i] != nil {
+			t.Fatalf("Store: %v", errs[i])
+		}
+		if codes[i] != codes[0] {
+			t.Errorf("got codes %q and %q for the same URL", codes[0], codes[i])
+		}
+		if created[i] {
+			createdCount++
+		}
+	}
+	if createdCount != 1 {
+		t.Errorf("%d calls reported a new link, want 1", createdCount)
+	}
+
+	var rows, withoutCode int
+	err := us.dbConn.QueryRow(ctx,
+		"SELECT count(*), count(*) FILTER (WHERE short_code IS NULL) FROM links WHERE original_url = $1",
+		originalURL,
+	).Scan(&rows, &withoutCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 || withoutCode != 0 {
+		t.Errorf("got %d rows, %d without a code, want 1 row with a code", rows, withoutCode)
+	}
+}
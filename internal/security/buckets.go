@@ -0,0 +1,60 @@
+package security
+
+import (
+	"fmt"
+	"net"
+)
+
+// ClientBuckets groups client addresses into the networks that rate limits
+// are applied to. A single IPv6 host usually controls at least a /64, so
+// limiting full IPv6 addresses is trivially evaded
+type ClientBuckets struct {
+	ipv4Mask  net.IPMask
+	ipv6Mask  net.IPMask
+	allowlist []*net.IPNet
+}
+
+// NewClientBuckets creates buckets of the given prefix lengths. Clients in
+// the allowlist CIDRs bypass rate limits entirely
+func NewClientBuckets(ipv4Prefix, ipv6Prefix int, allowlist []string) (*ClientBuckets, error) {
+	if ipv4Prefix < 1 || ipv4Prefix > 32 {
+		return nil, fmt.Errorf("invalid IPv4 prefix length %d", ipv4Prefix)
+	}
+	if ipv6Prefix < 1 || ipv6Prefix > 128 {
+		return nil, fmt.Errorf("invalid IPv6 prefix length %d", ipv6Prefix)
+	}
+
+	nets, err := parseCIDRs(allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowlist entry: %w", err)
+	}
+
+	return &ClientBuckets{
+		ipv4Mask:  net.CIDRMask(ipv4Prefix, 32),
+		ipv6Mask:  net.CIDRMask(ipv6Prefix, 128),
+		allowlist: nets,
+	}, nil
+}
+
+// Bucket returns the network of the client IP in CIDR notation, or the
+// input unchanged if it is not an IP address
+func (b *ClientBuckets) Bucket(clientIP string) string {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return clientIP
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ones, _ := b.ipv4Mask.Size()
+		return fmt.Sprintf("%s/%d", ip4.Mask(b.ipv4Mask), ones)
+	}
+
+	ones, _ := b.ipv6Mask.Size()
+	return fmt.Sprintf("%s/%d", ip.Mask(b.ipv6Mask), ones)
+}
+
+// Allowlisted reports whether the client IP bypasses rate limits
+func (b *ClientBuckets) Allowlisted(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	return ip != nil && containsIP(b.allowlist, ip)
+}
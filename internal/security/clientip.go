@@ -0,0 +1,152 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarding headers a trusted proxy may write the client address to
+const (
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderForwarded     = "forwarded"
+)
+
+// ProxyResolver resolves the real client IP of a request, only honouring
+// the forwarding header written by trusted proxies
+type ProxyResolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewProxyResolver parses a list of trusted proxy CIDRs. Bare IP addresses
+// are accepted and treated as single-host networks. header is the one
+// forwarding header the proxies write, HeaderXForwardedFor when empty
+func NewProxyResolver(cidrs []string, header string) (*ProxyResolver, error) {
+	trusted, err := parseCIDRs(cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+
+	header = strings.ToLower(header)
+	switch header {
+	case "":
+		header = HeaderXForwardedFor
+	case HeaderXForwardedFor, HeaderForwarded:
+	default:
+		return nil, fmt.Errorf("trusted proxy header must be %s or %s, got %q", HeaderXForwardedFor, HeaderForwarded, header)
+	}
+	return &ProxyResolver{trusted: trusted, header: header}, nil
+}
+
+// ClientIP returns the address of the client that sent the request.
+// Starting from the direct peer, the forwarding chain is walked right to
+// left and the first address not belonging to a trusted proxy is returned.
+// Only the configured header is read: proxies pass the other one through
+// from the client unchanged, so it could be forged
+func (p *ProxyResolver) ClientIP(r *http.Request) string {
+	peer := parseHost(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if !p.isTrusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	if p.header == HeaderForwarded {
+		hops = parseForwarded(r.Header.Values("Forwarded"))
+	} else {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHost(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Unknown or obfuscated hop: nothing beyond it can be trusted
+			break
+		}
+		client = ip
+		if !p.isTrusted(ip) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func (p *ProxyResolver) isTrusted(ip net.IP) bool {
+	return containsIP(p.trusted, ip)
+}
+
+// parseCIDRs parses a list of CIDRs, skipping empty entries. Bare IP
+// addresses are treated as single-host networks
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", ip, bits)
+		}
+
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded extracts the for= parameters of every element in the
+// given Forwarded header values, in order
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				hops = append(hops, strings.Trim(val, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// parseHost parses an IP address with an optional port, accepting the
+// bracketed IPv6 form used by RemoteAddr and the Forwarded header
+func parseHost(hostport string) net.IP {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		hostport = host
+	}
+	hostport = strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+	return net.ParseIP(hostport)
+}
@@ -9,6 +9,8 @@ import (
 	"regexp"
 	"strings"
 	"unicode"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 const (
@@ -310,23 +312,37 @@ func ValidateAndSanitizeURL(urlStr, ownDomain string) (string, error) {
 	return validatedURL, nil
 }
 
-// GetRateLimitIdentifier returns a unique identifier for rate limiting
-// Prefers IP address, falls back to device ID if needed
-func GetRateLimitIdentifier(remoteAddr string, xForwardedFor string, deviceID string) string {
-	// Extract IP from X-Forwarded-For or RemoteAddr
-	host, _, _ := net.SplitHostPort(remoteAddr)
-
-	if xForwardedFor != "" {
-		// Use first IP in chain (most trusted)
-		ips := strings.Split(xForwardedFor, ",")
-		if len(ips) > 0 {
-			host = strings.TrimSpace(ips[0])
-		}
+// RegistrableDomain returns the eTLD+1 of the URL host, e.g. example.co.uk
+// for https://www.example.co.uk/path
+func RegistrableDomain(urlStr string) (string, error) {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURLFormat, err)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(parsedURL.Hostname()), ".")
+	if host == "" {
+		return "", ErrInvalidURLFormat
 	}
 
+	// IP addresses have no public suffix, the address itself is the domain
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURLFormat, err)
+	}
+	return domain, nil
+}
+
+// GetRateLimitIdentifier returns a unique identifier for rate limiting
+// Prefers the resolved client IP, falls back to device ID if needed
+func GetRateLimitIdentifier(clientIP string, deviceID string) string {
 	// Prefer IP-based rate limiting for reliability
-	if host != "" && host != "" {
-		return fmt.Sprintf("ip:%s", host)
+	if clientIP != "" {
+		return fmt.Sprintf("ip:%s", clientIP)
 	}
 
 	// Fall back to device ID
@@ -334,8 +350,7 @@ func GetRateLimitIdentifier(remoteAddr string, xForwardedFor string, deviceID st
 		return fmt.Sprintf("cookie:%s", deviceID)
 	}
 
-	// Last resort: use remote address
-	return fmt.Sprintf("remote:%s", remoteAddr)
+	return "unknown"
 }
 
 // ValidateContentType ensures content type is safe
@@ -1,12 +1,240 @@
 package util
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 )
 
-func GenerateShortCode(secret string, id int64) string {
-	h := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", secret, id)))
-	return base64.RawURLEncoding.EncodeToString(h[:6])
+const (
+	// CodeHashed derives the code from a keyed hash of the link id.
+	CodeHashed = "hashed"
+	// CodeSequence encodes the link id itself, so codes are as short as
+	// possible but reveal how many links exist.
+	CodeSequence = "sequence"
+	// CodeRandom picks every character at random.
+	CodeRandom = "random"
+	// CodeWords joins random words from a built-in list.
+	CodeWords = "words"
+
+	Base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// ambiguousChars are easily confused when a code is read aloud or
+	// typed from print.
+	ambiguousChars = "0O1lI"
+)
+
+var (
+	ErrCodeExhausted = errors.New("no other short code available for this link")
+	// ErrCodeReserved is returned for a code that must never be handed
+	// out. Callers treat it like a code that is already taken.
+	ErrCodeReserved = errors.New("short code is reserved")
+)
+
+// CodeGenerator produces the short code for a newly stored link. attempt is
+// zero on the first call and is increased each time the previous code was
+// already taken.
+type CodeGenerator interface {
+	Generate(id int64, attempt int) (string, error)
+}
+
+type CodeOptions struct {
+	Strategy string
+	// Length is the number of characters, or the number of words for
+	// CodeWords. Zero picks the default of the strategy.
+	Length int
+	// Alphabet defaults to Base62Alphabet. Not used by CodeWords.
+	Alphabet         string
+	ExcludeAmbiguous bool
+	// Secret keys the hash of CodeHashed.
+	Secret string
+	// Reserved codes are never generated, such as the scanner honeypots.
+	Reserved []string
+}
+
+func NewCodeGenerator(opts CodeOptions) (CodeGenerator, error) {
+	codes, err := newCodeGenerator(opts)
+	if err != nil || len(opts.Reserved) == 0 {
+		return codes, err
+	}
+
+	reserved := make(map[string]struct{}, len(opts.Reserved))
+	for _, code := range opts.Reserved {
+		reserved[code] = struct{}{}
+	}
+	return &reservedGenerator{codes: codes, reserved: reserved}, nil
+}
+
+func newCodeGenerator(opts CodeOptions) (CodeGenerator, error) {
+	// Without any encoding options the hashed strategy keeps the encoding
+	// codes had before the strategies existed.
+	if (opts.Strategy == CodeHashed || opts.Strategy == "") && opts.Length == 0 && opts.Alphabet == "" && !opts.ExcludeAmbiguous {
+		return &hashedGenerator{secret: opts.Secret}, nil
+	}
+
+	alphabet := opts.Alphabet
+	if alphabet == "" {
+		alphabet = Base62Alphabet
+	}
+	if opts.ExcludeAmbiguous {
+		alphabet = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(ambiguousChars, r) {
+				return -1
+			}
+			return r
+		}, alphabet)
+	}
+	chars := []rune(alphabet)
+	if len(chars) < 2 {
+		return nil, errors.New("short code alphabet needs at least two characters")
+	}
+	seen := map[rune]bool{}
+	for _, r := range chars {
+		if seen[r] {
+			return nil, fmt.Errorf("short code alphabet repeats %q", r)
+		}
+		seen[r] = true
+	}
+
+	if opts.Strategy == CodeWords {
+		words := opts.Length
+		if words == 0 {
+			words = 3
+		}
+		if words < 2 || words > 8 {
+			return nil, fmt.Errorf("short codes need 2 to 8 words, got %d", words)
+		}
+		return &wordsGenerator{words: words}, nil
+	}
+
+	length := opts.Length
+	if length == 0 {
+		length = 8
+	}
+	if length < 4 || length > 32 {
+		return nil, fmt.Errorf("short code length must be between 4 and 32, got %d", length)
+	}
+
+	switch opts.Strategy {
+	case CodeHashed, "":
+		return &hashedGenerator{secret: opts.Secret, alphabet: chars, length: length}, nil
+	case CodeSequence:
+		return &sequenceGenerator{alphabet: chars, length: length}, nil
+	case CodeRandom:
+		return &randomGenerator{alphabet: chars, length: length}, nil
+	default:
+		return nil, fmt.Errorf("unknown short code strategy %q", opts.Strategy)
+	}
+}
+
+// reservedGenerator refuses the codes of the wrapped generator that are
+// reserved, so the caller moves on to the next attempt.
+type reservedGenerator struct {
+	codes    CodeGenerator
+	reserved map[string]struct{}
+}
+
+func (g *reservedGenerator) Generate(id int64, attempt int) (string, error) {
+	code, err := g.codes.Generate(id, attempt)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := g.reserved[code]; ok {
+		return "", ErrCodeReserved
+	}
+	return code, nil
+}
+
+// hashedGenerator encodes in alphabet when one is set, and otherwise in
+// unpadded base64url of the first six bytes of the hash.
+type hashedGenerator struct {
+	secret   string
+	alphabet []rune
+	length   int
+}
+
+// Generate hashes the secret and id, adding the attempt on retries so that
+// a collision yields a different code.
+func (g *hashedGenerator) Generate(id int64, attempt int) (string, error) {
+	input := fmt.Sprintf("%s:%d", g.secret, id)
+	if attempt > 0 {
+		input = fmt.Sprintf("%s:%d", input, attempt)
+	}
+	h := sha256.Sum256([]byte(input))
+	if g.alphabet == nil {
+		return base64.RawURLEncoding.EncodeToString(h[:6]), nil
+	}
+	return encode(new(big.Int).SetBytes(h[:]), g.alphabet, g.length), nil
+}
+
+type sequenceGenerator struct {
+	alphabet []rune
+	length   int
+}
+
+// Generate encodes the id in the alphabet, padded to the configured length.
+// Ids are unique, so a collision can only come from a code created with
+// another strategy and cannot be retried.
+func (g *sequenceGenerator) Generate(id int64, attempt int) (string, error) {
+	if attempt > 0 {
+		return "", ErrCodeExhausted
+	}
+
+	base := int64(len(g.alphabet))
+	var digits []rune
+	for n := id; n > 0; n /= base {
+		digits = append(digits, g.alphabet[n%base])
+	}
+	for len(digits) < g.length {
+		digits = append(digits, g.alphabet[0])
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits), nil
+}
+
+type randomGenerator struct {
+	alphabet []rune
+	length   int
+}
+
+func (g *randomGenerator) Generate(id int64, attempt int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(int64(len(g.alphabet))), big.NewInt(int64(g.length)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return encode(n, g.alphabet, g.length), nil
+}
+
+type wordsGenerator struct {
+	words int
+}
+
+func (g *wordsGenerator) Generate(id int64, attempt int) (string, error) {
+	picked := make([]string, g.words)
+	for i := range picked {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeWords))))
+		if err != nil {
+			return "", err
+		}
+		picked[i] = codeWords[n.Int64()]
+	}
+	return strings.Join(picked, "-"), nil
+}
+
+// encode writes the low digits of n in the alphabet, length characters long.
+func encode(n *big.Int, alphabet []rune, length int) string {
+	base := big.NewInt(int64(len(alphabet)))
+	digit := new(big.Int)
+	code := make([]rune, length)
+	for i := length - 1; i >= 0; i-- {
+		n.DivMod(n, base, digit)
+		code[i] = alphabet[digit.Int64()]
+	}
+	return string(code)
 }
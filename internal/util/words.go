@@ -0,0 +1,36 @@
+package util
+
+// codeWords are short, common and unambiguous words for CodeWords codes.
+var codeWords = []string{
+	"acorn", "amber", "anchor", "apple", "arrow", "aspen", "autumn", "badge",
+	"bamboo", "banjo", "barley", "basil", "beach", "beacon", "berry", "birch",
+	"bison", "blaze", "bloom", "bluff", "bonsai", "border", "bottle", "breeze",
+	"brick", "bridge", "brook", "bubble", "bucket", "button", "cabin", "cactus",
+	"camel", "candle", "canoe", "canyon", "carbon", "cargo", "carpet", "castle",
+	"cedar", "cello", "chalk", "cherry", "chess", "cider", "cinder", "circle",
+	"citrus", "clay", "cliff", "clover", "cobalt", "cocoa", "comet", "copper",
+	"coral", "cotton", "crane", "crater", "cricket", "crystal", "cumin",
+	"daisy", "dawn", "delta", "desert", "dingo", "dolphin", "dome", "dove",
+	"dragon", "drift", "drum", "dune", "eagle", "echo", "ember", "emerald",
+	"fable", "falcon", "fern", "ferry", "fiddle", "field", "finch", "fjord",
+	"flame", "flint", "flute", "forest", "fossil", "fox", "galaxy", "garden",
+	"garnet", "gecko", "geyser", "ginger", "glacier", "globe", "granite",
+	"grape", "gravel", "grove", "gull", "harbor", "hazel", "heron", "hickory",
+	"hill", "honey", "horizon", "igloo", "indigo", "iris", "island",
+	"ivory", "jade", "jasmine", "jelly", "jungle", "kayak", "kelp", "kettle",
+	"kiwi", "koala", "lagoon", "lantern", "lark", "lava", "lemon", "lilac",
+	"lily", "linen", "lotus", "lunar", "lynx", "magnet", "mango", "maple",
+	"marble", "meadow", "melon", "mesa", "meteor", "mint", "mist", "moose",
+	"mosaic", "moss", "nectar", "nest", "nickel", "noodle", "nutmeg", "oasis",
+	"ocean", "olive", "onyx", "opal", "orbit", "orchid", "otter", "owl",
+	"paddle", "panda", "paper", "parrot", "pebble", "pepper", "petal", "piano",
+	"pine", "planet", "plum", "polar", "pond", "poppy", "prism", "puffin",
+	"pumpkin", "quartz", "quill", "rabbit", "radar", "raven", "reef", "ribbon",
+	"river", "robin", "rocket", "rose", "ruby", "saddle", "saffron", "sage",
+	"salmon", "sand", "satin", "sequoia", "shadow", "shell", "sierra", "silver",
+	"sketch", "sky", "slate", "snow", "solar", "sonnet", "spark", "spruce",
+	"squid", "star", "stone", "storm", "summit", "sun", "swan", "tango", "tea",
+	"thistle", "thunder", "tiger", "timber", "topaz", "tulip", "tundra",
+	"turtle", "valley", "velvet", "violet", "walnut", "wave", "willow", "wind",
+	"winter", "wolf", "wren", "yarrow", "zebra", "zephyr", "zinc",
+}